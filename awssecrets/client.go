@@ -2,53 +2,192 @@ package awssecrets
 
 import (
 	"aws-secret-manager-cgi/common"
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
-func createAWSClient(secretManagerConfig common.SecretManagerConfig) (*secretsmanager.Client, error) {
+// defaultRegion is used when the store config sets no region, unless a profile supplies one
+const defaultRegion = "us-east-1"
+
+// stsExpiryWindow is how long before expiry assumed role credentials are refreshed
+const stsExpiryWindow = 5 * time.Minute
+
+func createAWSClient(secretManagerConfig common.SecretManagerConfig) (*secretsmanager.Client, aws.Config, error) {
 	ctx := context.Background()
 	var awsConfig aws.Config
 	var err error
 
-	if secretManagerConfig.Region == "" {
-		secretManagerConfig.Region = "us-east-1"
+	retryer, err := createRetryer(secretManagerConfig)
+	if err != nil {
+		logrus.Errorf("Failed to configure AWS client: %v", err)
+		return nil, aws.Config{}, err
 	}
-
-	retryer := createRetryer()
 	switch {
 	case secretManagerConfig.AssumeIamRoleOnRunner:
-		awsConfig, err = loadIAMRoleConfig(ctx, secretManagerConfig.Region, retryer)
+		awsConfig, err = loadIAMRoleConfig(ctx, secretManagerConfig, retryer)
 	case secretManagerConfig.AssumeStsRoleOnRunner:
 		awsConfig, err = loadSTSRoleConfig(ctx, secretManagerConfig, retryer)
+	case secretManagerConfig.AssumeWebIdentity:
+		awsConfig, err = loadWebIdentityConfig(ctx, secretManagerConfig, retryer)
 	default:
 		awsConfig, err = loadStaticCredentialsConfig(ctx, secretManagerConfig, retryer)
 	}
 	if err != nil {
 		logrus.Errorf("Failed to configure AWS client: %v", err)
-		return nil, err
+		return nil, aws.Config{}, err
+	}
+	logrus.Infof("Successfully configured AWS client for region: %s", awsConfig.Region)
+	return newSecretsManagerClient(awsConfig, secretManagerConfig), awsConfig, nil
+}
+
+// getCredentialSource names the credential mode createAWSClient selects for the given config
+func getCredentialSource(secretManagerConfig common.SecretManagerConfig) string {
+	switch {
+	case secretManagerConfig.AssumeIamRoleOnRunner:
+		return "iam_role"
+	case secretManagerConfig.AssumeStsRoleOnRunner:
+		return "sts"
+	case secretManagerConfig.AssumeWebIdentity:
+		return "web_identity"
+	default:
+		return "static"
+	}
+}
+
+// loadConfig loads the AWS configuration with the region, retry and endpoint settings from the store config.
+// Without a region in the store config, defaultRegion is used; only a selected profile may supply its own
+// region instead, so runners setting AWS_REGION don't move existing store configs to another region.
+func loadConfig(ctx context.Context, secretManagerConfig common.SecretManagerConfig, retryer func() aws.Retryer, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithDefaultRegion(defaultRegion),
+		config.WithRetryer(retryer),
+	}
+	region := secretManagerConfig.Region
+	if region == "" && secretManagerConfig.Profile == "" {
+		region = defaultRegion
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	if secretManagerConfig.UseFIPSEndpoint {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if secretManagerConfig.UseDualStackEndpoint {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	// profiles may use credential_process or SSO, which the default chain resolves from the shared files
+	if secretManagerConfig.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(secretManagerConfig.Profile))
+	}
+	if len(secretManagerConfig.SharedConfigFiles) > 0 {
+		opts = append(opts, config.WithSharedConfigFiles(secretManagerConfig.SharedConfigFiles))
 	}
-	logrus.Infof("Successfully configured AWS client for region: %s", secretManagerConfig.Region)
-	return secretsmanager.NewFromConfig(awsConfig), nil
+	if len(secretManagerConfig.SharedCredentialsFiles) > 0 {
+		opts = append(opts, config.WithSharedCredentialsFiles(secretManagerConfig.SharedCredentialsFiles))
+	}
+	if secretManagerConfig.CredentialProcessTimeout > 0 {
+		opts = append(opts, config.WithProcessCredentialOptions(func(o *processcreds.Options) {
+			o.Timeout = time.Duration(secretManagerConfig.CredentialProcessTimeout) * time.Second
+		}))
+	}
+	httpClient, err := createHTTPClient(secretManagerConfig)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if httpClient != nil {
+		opts = append(opts, config.WithHTTPClient(httpClient))
+	}
+	if secretManagerConfig.CABundle != "" {
+		caBundle, err := getCABundle(secretManagerConfig.CABundle)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		opts = append(opts, config.WithCustomCABundle(bytes.NewReader(caBundle)))
+	}
+	return config.LoadDefaultConfig(ctx, append(opts, optFns...)...)
 }
 
-func createRetryer() func() aws.Retryer {
-	return func() aws.Retryer {
-		return retry.NewStandard()
+// getCABundle returns the PEM bundle given inline or read from the given file path
+func getCABundle(caBundle string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(caBundle), "-----BEGIN") {
+		return []byte(caBundle), nil
 	}
+	pem, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	return pem, nil
+}
+
+func newSecretsManagerClient(awsConfig aws.Config, secretManagerConfig common.SecretManagerConfig) *secretsmanager.Client {
+	return secretsmanager.NewFromConfig(awsConfig, func(o *secretsmanager.Options) {
+		if secretManagerConfig.EndpointURL != "" {
+			o.BaseEndpoint = aws.String(secretManagerConfig.EndpointURL)
+		}
+	})
+}
+
+func newSTSClient(awsConfig aws.Config, secretManagerConfig common.SecretManagerConfig) *sts.Client {
+	return sts.NewFromConfig(awsConfig, func(o *sts.Options) {
+		if secretManagerConfig.STSEndpointURL != "" {
+			o.BaseEndpoint = aws.String(secretManagerConfig.STSEndpointURL)
+		}
+	})
 }
 
-func loadIAMRoleConfig(ctx context.Context, region string, retryer func() aws.Retryer) (aws.Config, error) {
+// createRetryer builds the retryer selected by retry_mode, defaulting to the SDK standard retryer
+func createRetryer(secretManagerConfig common.SecretManagerConfig) (func() aws.Retryer, error) {
+	standardOptions := func(o *retry.StandardOptions) {
+		if secretManagerConfig.MaxAttempts > 0 {
+			o.MaxAttempts = secretManagerConfig.MaxAttempts
+		}
+		if secretManagerConfig.MaxBackoff > 0 {
+			o.MaxBackoff = time.Duration(secretManagerConfig.MaxBackoff) * time.Second
+		}
+		// the token bucket limits how many retries a client may make when calls keep failing
+		if secretManagerConfig.DisableRetryTokenBucket {
+			o.RateLimiter = ratelimit.None
+		} else if secretManagerConfig.RetryTokenBucketSize > 0 {
+			o.RateLimiter = ratelimit.NewTokenRateLimit(uint(secretManagerConfig.RetryTokenBucketSize))
+		}
+	}
+
+	switch strings.ToLower(secretManagerConfig.RetryMode) {
+	case "", string(aws.RetryModeStandard):
+		return func() aws.Retryer {
+			return retry.NewStandard(standardOptions)
+		}, nil
+	case string(aws.RetryModeAdaptive):
+		return func() aws.Retryer {
+			return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+				o.StandardOptions = append(o.StandardOptions, standardOptions)
+			})
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported retry mode: %s", secretManagerConfig.RetryMode)
+	}
+}
+
+func loadIAMRoleConfig(ctx context.Context, secretManagerConfig common.SecretManagerConfig, retryer func() aws.Retryer) (aws.Config, error) {
 	logrus.Info("Assuming IAM role on runner")
-	return config.LoadDefaultConfig(ctx, config.WithRegion(region), config.WithRetryer(retryer))
+	return loadConfig(ctx, secretManagerConfig, retryer)
 }
 
 func loadSTSRoleConfig(ctx context.Context, secretManagerConfig common.SecretManagerConfig, retryer func() aws.Retryer) (aws.Config, error) {
@@ -58,11 +197,17 @@ func loadSTSRoleConfig(ctx context.Context, secretManagerConfig common.SecretMan
 		return aws.Config{}, fmt.Errorf("failed to get STS credentials: %w", err)
 	}
 
-	return config.LoadDefaultConfig(ctx,
-		config.WithRegion(secretManagerConfig.Region),
-		config.WithCredentialsProvider(credProvider),
-		config.WithRetryer(retryer),
-	)
+	return loadConfig(ctx, secretManagerConfig, retryer, config.WithCredentialsProvider(credProvider))
+}
+
+func loadWebIdentityConfig(ctx context.Context, secretManagerConfig common.SecretManagerConfig, retryer func() aws.Retryer) (aws.Config, error) {
+	logrus.Infof("Assuming role with web identity: %s", secretManagerConfig.RoleArn)
+	credProvider, err := getWebIdentityCredentialsProvider(ctx, secretManagerConfig)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to get web identity credentials: %w", err)
+	}
+
+	return loadConfig(ctx, secretManagerConfig, retryer, config.WithCredentialsProvider(credProvider))
 }
 
 func loadStaticCredentialsConfig(ctx context.Context, secretManagerConfig common.SecretManagerConfig, retryer func() aws.Retryer) (aws.Config, error) {
@@ -75,46 +220,195 @@ func loadStaticCredentialsConfig(ctx context.Context, secretManagerConfig common
 		return aws.Config{}, fmt.Errorf("SecretKey not provided")
 	}
 	credProvider := credentials.NewStaticCredentialsProvider(secretManagerConfig.AccessKey, secretManagerConfig.SecretKey, "")
-	return config.LoadDefaultConfig(ctx,
-		config.WithRegion(secretManagerConfig.Region),
-		config.WithCredentialsProvider(credProvider),
-		config.WithRetryer(retryer),
-	)
+	return loadConfig(ctx, secretManagerConfig, retryer, config.WithCredentialsProvider(credProvider))
 }
 
 func getSTSCredentialsProvider(ctx context.Context, secretManagerConfig common.SecretManagerConfig) (aws.CredentialsProvider, error) {
-	if secretManagerConfig.RoleArn == "" {
-		return nil, fmt.Errorf("RoleARN must be provided for STS role assumption")
+	hops := stsRoleHops(secretManagerConfig)
+	for i, hop := range hops {
+		if hop.RoleArn == "" {
+			if len(hops) == 1 {
+				return nil, fmt.Errorf("RoleARN must be provided for STS role assumption")
+			}
+			return nil, fmt.Errorf("RoleARN must be provided for role chain hop %d", i+1)
+		}
 	}
 
-	defaultConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(secretManagerConfig.Region))
+	stsClient, err := createSTSClient(ctx, secretManagerConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load default configuration: %w", err)
+		return nil, err
 	}
 
-	stsClient := sts.NewFromConfig(defaultConfig)
+	var credProvider aws.CredentialsProvider
+	for i, hop := range hops {
+		if i > 0 {
+			// each hop is assumed with the credentials of the previous one
+			stsClient = sts.New(stsClient.Options(), func(o *sts.Options) {
+				o.Credentials = credProvider
+			})
+		}
+
+		var provider aws.CredentialsProvider = newAssumeRoleProvider(stsClient, hop, secretManagerConfig, i, len(hops))
+		if len(hops) > 1 {
+			logrus.Infof("Assuming role chain hop %d: %s", i+1, hop.RoleArn)
+			provider = &roleChainHopProvider{hop: i + 1, roleArn: hop.RoleArn, provider: provider}
+		}
 
-	input := &sts.AssumeRoleInput{
-		RoleArn:         aws.String(secretManagerConfig.RoleArn),
-		RoleSessionName: aws.String(uuid.New().String()),
+		// the cache re-assumes the role before the session token expires
+		credCache := aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = stsExpiryWindow
+		})
+
+		// assume the role up front so a bad role or trust policy fails client creation, as before
+		if _, err := credCache.Retrieve(ctx); err != nil {
+			return nil, fmt.Errorf("failed to assume role: %w", err)
+		}
+		credProvider = credCache
 	}
 
-	if secretManagerConfig.AssumeStsRoleDuration > 0 {
-		input.DurationSeconds = aws.Int32(int32(secretManagerConfig.AssumeStsRoleDuration))
+	return credProvider, nil
+}
+
+// stsRoleHops returns the roles to assume in order; a single role_arn is a chain of one
+func stsRoleHops(secretManagerConfig common.SecretManagerConfig) []common.RoleHop {
+	if len(secretManagerConfig.RoleChain) > 0 {
+		return secretManagerConfig.RoleChain
 	}
+	return []common.RoleHop{{
+		RoleArn:    secretManagerConfig.RoleArn,
+		ExternalId: secretManagerConfig.ExternalName,
+		Duration:   secretManagerConfig.AssumeStsRoleDuration,
+	}}
+}
+
+// newAssumeRoleProvider builds the provider for hop i of n. Session tags and source identity are set
+// on the first hop and carried through the chain by STS; the session policy scopes down the final session.
+func newAssumeRoleProvider(stsClient *sts.Client, hop common.RoleHop, secretManagerConfig common.SecretManagerConfig, i, n int) *stscreds.AssumeRoleProvider {
+	return stscreds.NewAssumeRoleProvider(stsClient, hop.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = hop.SessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = secretManagerConfig.RoleSessionName
+		}
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = uuid.New().String()
+		}
+		if hop.Duration > 0 {
+			o.Duration = time.Duration(hop.Duration) * time.Second
+		}
+		if hop.ExternalId != "" {
+			o.ExternalID = aws.String(hop.ExternalId)
+		}
+		if i == 0 {
+			if secretManagerConfig.SourceIdentity != "" {
+				o.SourceIdentity = aws.String(secretManagerConfig.SourceIdentity)
+			}
+			o.Tags = getSessionTags(secretManagerConfig.SessionTags)
+			o.TransitiveTagKeys = secretManagerConfig.TransitiveTagKeys
+		}
+		if i == n-1 && secretManagerConfig.SessionPolicy != "" {
+			o.Policy = aws.String(secretManagerConfig.SessionPolicy)
+		}
+	})
+}
 
-	if secretManagerConfig.ExternalName != "" {
-		input.ExternalId = aws.String(secretManagerConfig.ExternalName)
+// getSessionTags converts the configured session tags, sorted by key so requests are deterministic
+func getSessionTags(tags map[string]string) []ststypes.Tag {
+	if len(tags) == 0 {
+		return nil
 	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sessionTags := make([]ststypes.Tag, 0, len(keys))
+	for _, k := range keys {
+		sessionTags = append(sessionTags, ststypes.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		})
+	}
+	return sessionTags
+}
+
+// roleChainHopProvider reports which hop of a role chain failed, including on later refreshes
+type roleChainHopProvider struct {
+	hop      int
+	roleArn  string
+	provider aws.CredentialsProvider
+}
 
-	output, err := stsClient.AssumeRole(ctx, input)
+func (p *roleChainHopProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to assume role: %w", err)
+		return aws.Credentials{}, fmt.Errorf("role chain hop %d (%s) failed: %w", p.hop, p.roleArn, err)
 	}
+	return creds, nil
+}
+
+func getWebIdentityCredentialsProvider(ctx context.Context, secretManagerConfig common.SecretManagerConfig) (aws.CredentialsProvider, error) {
+	if secretManagerConfig.RoleArn == "" {
+		return nil, fmt.Errorf("RoleARN must be provided for web identity role assumption")
+	}
+
+	var tokenRetriever stscreds.IdentityTokenRetriever
+	switch {
+	case secretManagerConfig.WebIdentityToken != "":
+		tokenRetriever = staticIdentityToken(secretManagerConfig.WebIdentityToken)
+	case secretManagerConfig.WebIdentityTokenFile != "":
+		// the file is re-read on every refresh so rotated tokens are picked up
+		tokenRetriever = stscreds.IdentityTokenFile(secretManagerConfig.WebIdentityTokenFile)
+	default:
+		return nil, fmt.Errorf("web identity token or token file must be provided for web identity role assumption")
+	}
+
+	stsClient, err := createSTSClient(ctx, secretManagerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := stscreds.NewWebIdentityRoleProvider(stsClient, secretManagerConfig.RoleArn, tokenRetriever, func(o *stscreds.WebIdentityRoleOptions) {
+		o.RoleSessionName = secretManagerConfig.RoleSessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = uuid.New().String()
+		}
+		if secretManagerConfig.AssumeStsRoleDuration > 0 {
+			o.Duration = time.Duration(secretManagerConfig.AssumeStsRoleDuration) * time.Second
+		}
+		if secretManagerConfig.SessionPolicy != "" {
+			o.Policy = aws.String(secretManagerConfig.SessionPolicy)
+		}
+	})
+
+	credCache := aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = stsExpiryWindow
+	})
+
+	if _, err := credCache.Retrieve(ctx); err != nil {
+		return nil, fmt.Errorf("failed to assume role with web identity: %w", err)
+	}
+
+	return credCache, nil
+}
+
+// createSTSClient builds an STS client from the runner's default configuration.
+// The endpoint can be pointed at a local STS stand-in with sts_endpoint_url or AWS_ENDPOINT_URL_STS.
+func createSTSClient(ctx context.Context, secretManagerConfig common.SecretManagerConfig) (*sts.Client, error) {
+	retryer, err := createRetryer(secretManagerConfig)
+	if err != nil {
+		return nil, err
+	}
+	defaultConfig, err := loadConfig(ctx, secretManagerConfig, retryer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default configuration: %w", err)
+	}
+	return newSTSClient(defaultConfig, secretManagerConfig), nil
+}
+
+// staticIdentityToken is a web identity token passed inline in the store config
+type staticIdentityToken string
 
-	return credentials.NewStaticCredentialsProvider(
-		*output.Credentials.AccessKeyId,
-		*output.Credentials.SecretAccessKey,
-		*output.Credentials.SessionToken,
-	), nil
+func (t staticIdentityToken) GetIdentityToken() ([]byte, error) {
+	return []byte(t), nil
 }
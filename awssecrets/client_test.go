@@ -0,0 +1,167 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stsStandIn answers AssumeRoleWithWebIdentity and records the tokens it was called with
+type stsStandIn struct {
+	mu     sync.Mutex
+	tokens []string
+	expiry time.Duration
+}
+
+func (s *stsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "AssumeRoleWithWebIdentity" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.tokens = append(s.tokens, r.Form.Get("WebIdentityToken"))
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>AKIDWEBIDENTITY</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+  <ResponseMetadata><RequestId>request</RequestId></ResponseMetadata>
+</AssumeRoleWithWebIdentityResponse>`, time.Now().Add(s.expiry).UTC().Format(time.RFC3339))
+}
+
+func (s *stsStandIn) calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokens...)
+}
+
+func newSTSStandIn(t *testing.T, expiry time.Duration) (*stsStandIn, common.SecretManagerConfig) {
+	t.Helper()
+	// keep the runner's own AWS configuration out of the test
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	standIn := &stsStandIn{expiry: expiry}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	return standIn, common.SecretManagerConfig{
+		Region:            "us-east-1",
+		AssumeWebIdentity: true,
+		RoleArn:           "arn:aws:iam::123456789012:role/web-identity",
+		STSEndpointURL:    server.URL,
+	}
+}
+
+func TestWebIdentityInlineToken(t *testing.T) {
+	standIn, cfg := newSTSStandIn(t, time.Hour)
+	cfg.WebIdentityToken = "inline-token"
+
+	provider, err := getWebIdentityCredentialsProvider(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("getWebIdentityCredentialsProvider() error = %v", err)
+	}
+	creds, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyID != "AKIDWEBIDENTITY" {
+		t.Errorf("AccessKeyID = %q, want %q", creds.AccessKeyID, "AKIDWEBIDENTITY")
+	}
+	if got := standIn.calls(); len(got) != 1 || got[0] != "inline-token" {
+		t.Errorf("tokens sent = %v, want [inline-token]", got)
+	}
+}
+
+func TestWebIdentityTokenFileReadOnRefresh(t *testing.T) {
+	// credentials expiring inside the expiry window are refreshed on every retrieve
+	standIn, cfg := newSTSStandIn(t, time.Minute)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.WebIdentityTokenFile = tokenFile
+
+	provider, err := getWebIdentityCredentialsProvider(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("getWebIdentityCredentialsProvider() error = %v", err)
+	}
+	if err := os.WriteFile(tokenFile, []byte("rotated-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+
+	want := []string{"first-token", "rotated-token"}
+	if got := standIn.calls(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("tokens sent = %v, want %v", got, want)
+	}
+}
+
+func TestWebIdentityMissingToken(t *testing.T) {
+	standIn, cfg := newSTSStandIn(t, time.Hour)
+
+	_, err := getWebIdentityCredentialsProvider(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "web identity token or token file must be provided") {
+		t.Fatalf("getWebIdentityCredentialsProvider() error = %v, want missing token error", err)
+	}
+	if got := standIn.calls(); len(got) != 0 {
+		t.Errorf("tokens sent = %v, want no STS calls", got)
+	}
+}
+
+func TestLoadConfigRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte("[profile dev]\nregion = eu-west-1\n\n[profile noregion]\noutput = json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	tests := []struct {
+		envRegion string
+		profile   string
+		region    string
+		want      string
+	}{
+		{profile: "", region: "", want: defaultRegion},
+		{profile: "dev", region: "", want: "eu-west-1"},
+		{profile: "noregion", region: "", want: defaultRegion},
+		{profile: "dev", region: "ap-south-1", want: "ap-south-1"},
+		// the runner's region must not move store configs without a profile
+		{envRegion: "eu-central-1", profile: "", region: "", want: defaultRegion},
+		{envRegion: "eu-central-1", profile: "", region: "ap-south-1", want: "ap-south-1"},
+		{envRegion: "eu-central-1", profile: "dev", region: "", want: "eu-central-1"},
+	}
+	for _, tt := range tests {
+		t.Setenv("AWS_REGION", tt.envRegion)
+		cfg := common.SecretManagerConfig{Region: tt.region, Profile: tt.profile}
+		retryer, _ := createRetryer(cfg)
+		awsConfig, err := loadConfig(context.Background(), cfg, retryer)
+		if err != nil {
+			t.Fatalf("loadConfig(profile %q) error = %v", tt.profile, err)
+		}
+		if awsConfig.Region != tt.want {
+			t.Errorf("loadConfig(AWS_REGION %q, profile %q, region %q) region = %q, want %q",
+				tt.envRegion, tt.profile, tt.region, awsConfig.Region, tt.want)
+		}
+	}
+}
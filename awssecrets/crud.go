@@ -3,16 +3,24 @@ package awssecrets
 import (
 	"aws-secret-manager-cgi/common"
 	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
-// getSecret fetches the secret value from AWS Secrets Manager
-func getSecret(ctx context.Context, client *secretsmanager.Client, secretName string) (*secretsmanager.GetSecretValueOutput, error) {
+// getSecret fetches the secret value from AWS Secrets Manager, AWSCURRENT unless a version is given
+func getSecret(ctx context.Context, client *secretsmanager.Client, secretName string, version secretVersion) (*secretsmanager.GetSecretValueOutput, error) {
 	input := &secretsmanager.GetSecretValueInput{
 		SecretId: aws.String(secretName),
 	}
+	if version.versionId != "" {
+		input.VersionId = aws.String(version.versionId)
+	}
+	if version.versionStage != "" {
+		input.VersionStage = aws.String(version.versionStage)
+	}
 
 	output, err := client.GetSecretValue(ctx, input)
 	if err != nil {
@@ -22,15 +30,27 @@ func getSecret(ctx context.Context, client *secretsmanager.Client, secretName st
 	return output, nil
 }
 
-// createSecret creates the secret value in AWS Secrets Manager
-func createSecret(ctx context.Context, client *secretsmanager.Client, secret common.Secret) (*secretsmanager.CreateSecretOutput, error) {
+// createSecret creates the secret value in AWS Secrets Manager; base64 encoded values get an encoded version ID
+func createSecret(ctx context.Context, client *secretsmanager.Client, secret common.Secret, tags []types.Tag) (*secretsmanager.CreateSecretOutput, error) {
+	secretString, secretBinary, err := getSecretPayload(secret)
+	if err != nil {
+		return nil, err
+	}
 	input := &secretsmanager.CreateSecretInput{
-		Name:         aws.String(secret.Name),
-		SecretString: secret.Plaintext,
-		Tags: []types.Tag{{
-			Key:   aws.String("createdBy"),
-			Value: aws.String("Harness"),
-		}},
+		Name:              aws.String(secret.Name),
+		SecretString:      secretString,
+		SecretBinary:      secretBinary,
+		Tags:              tags,
+		AddReplicaRegions: getReplicaRegions(secret.ReplicaRegions),
+	}
+	if secret.Description != "" {
+		input.Description = aws.String(secret.Description)
+	}
+	if secret.KmsKeyId != "" {
+		input.KmsKeyId = aws.String(secret.KmsKeyId)
+	}
+	if isBase64Tagged(secret) && secretString != nil {
+		input.ClientRequestToken = aws.String(newEncodedVersionId())
 	}
 
 	output, err := client.CreateSecret(ctx, input)
@@ -41,11 +61,26 @@ func createSecret(ctx context.Context, client *secretsmanager.Client, secret com
 	return output, nil
 }
 
-// updateSecret updates the secret value in AWS Secrets Manager
+// updateSecret updates the secret value, and the description and KMS key if given, in AWS Secrets Manager;
+// base64 encoded values get an encoded version ID
 func updateSecret(ctx context.Context, client *secretsmanager.Client, secret common.Secret) (*secretsmanager.UpdateSecretOutput, error) {
+	secretString, secretBinary, err := getSecretPayload(secret)
+	if err != nil {
+		return nil, err
+	}
 	input := &secretsmanager.UpdateSecretInput{
 		SecretId:     aws.String(secret.Name),
-		SecretString: secret.Plaintext,
+		SecretString: secretString,
+		SecretBinary: secretBinary,
+	}
+	if secret.Description != "" {
+		input.Description = aws.String(secret.Description)
+	}
+	if secret.KmsKeyId != "" {
+		input.KmsKeyId = aws.String(secret.KmsKeyId)
+	}
+	if isBase64Tagged(secret) && secretString != nil {
+		input.ClientRequestToken = aws.String(newEncodedVersionId())
 	}
 
 	output, err := client.UpdateSecret(ctx, input)
@@ -56,11 +91,19 @@ func updateSecret(ctx context.Context, client *secretsmanager.Client, secret com
 	return output, nil
 }
 
-// deleteSecret deletes the secret value in AWS Secrets Manager
-func deleteSecret(ctx context.Context, client *secretsmanager.Client, secret common.Secret) (*secretsmanager.DeleteSecretOutput, error) {
+// deleteSecret deletes the secret value in AWS Secrets Manager, after the recovery window unless force deleted
+func deleteSecret(ctx context.Context, client *secretsmanager.Client, secret common.Secret, recoveryWindowDays int) (*secretsmanager.DeleteSecretOutput, error) {
 	input := &secretsmanager.DeleteSecretInput{
-		SecretId:                   aws.String(secret.Name),
-		ForceDeleteWithoutRecovery: aws.Bool(true),
+		SecretId: aws.String(secret.Name),
+	}
+	if secret.ForceDelete {
+		input.ForceDeleteWithoutRecovery = aws.Bool(true)
+	} else if recoveryWindowDays != 0 {
+		if recoveryWindowDays < MinRecoveryWindowDays || recoveryWindowDays > MaxRecoveryWindowDays {
+			return nil, fmt.Errorf("recovery window must be between %d and %d days, got %d",
+				MinRecoveryWindowDays, MaxRecoveryWindowDays, recoveryWindowDays)
+		}
+		input.RecoveryWindowInDays = aws.Int64(int64(recoveryWindowDays))
 	}
 
 	output, err := client.DeleteSecret(ctx, input)
@@ -70,3 +113,193 @@ func deleteSecret(ctx context.Context, client *secretsmanager.Client, secret com
 
 	return output, nil
 }
+
+// restoreSecret cancels the scheduled deletion of the secret in AWS Secrets Manager
+func restoreSecret(ctx context.Context, client *secretsmanager.Client, secretName string) (*secretsmanager.RestoreSecretOutput, error) {
+	input := &secretsmanager.RestoreSecretInput{
+		SecretId: aws.String(secretName),
+	}
+
+	output, err := client.RestoreSecret(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// rotateSecret starts a rotation in AWS Secrets Manager, first setting the rotation configuration if given
+func rotateSecret(ctx context.Context, client *secretsmanager.Client, secretName string, options *common.RotationOptions) (*secretsmanager.RotateSecretOutput, error) {
+	input := &secretsmanager.RotateSecretInput{
+		SecretId: aws.String(secretName),
+	}
+	if options != nil {
+		input.RotateImmediately = aws.Bool(options.RotateImmediately)
+		if options.LambdaArn != "" {
+			input.RotationLambdaARN = aws.String(options.LambdaArn)
+		}
+		input.RotationRules = getRotationRules(options.RotationRules)
+	}
+
+	output, err := client.RotateSecret(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// cancelRotateSecret turns off automatic rotation of the secret in AWS Secrets Manager
+func cancelRotateSecret(ctx context.Context, client *secretsmanager.Client, secretName string) (*secretsmanager.CancelRotateSecretOutput, error) {
+	input := &secretsmanager.CancelRotateSecretInput{
+		SecretId: aws.String(secretName),
+	}
+
+	output, err := client.CancelRotateSecret(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// replicateSecret replicates the secret to more regions in AWS Secrets Manager
+func replicateSecret(ctx context.Context, client *secretsmanager.Client, secretName string, regions []common.ReplicaRegion) (*secretsmanager.ReplicateSecretToRegionsOutput, error) {
+	input := &secretsmanager.ReplicateSecretToRegionsInput{
+		SecretId:          aws.String(secretName),
+		AddReplicaRegions: getReplicaRegions(regions),
+	}
+
+	output, err := client.ReplicateSecretToRegions(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// removeReplicaRegions deletes the replicas of the secret in the given regions in AWS Secrets Manager
+func removeReplicaRegions(ctx context.Context, client *secretsmanager.Client, secretName string, regions []common.ReplicaRegion) (*secretsmanager.RemoveRegionsFromReplicationOutput, error) {
+	input := &secretsmanager.RemoveRegionsFromReplicationInput{
+		SecretId: aws.String(secretName),
+	}
+	for _, region := range regions {
+		input.RemoveReplicaRegions = append(input.RemoveReplicaRegions, region.Region)
+	}
+
+	output, err := client.RemoveRegionsFromReplication(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// stopReplicationToReplica promotes a replica to a standalone secret; the client must be in the replica's region
+func stopReplicationToReplica(ctx context.Context, client *secretsmanager.Client, secretName string) (*secretsmanager.StopReplicationToReplicaOutput, error) {
+	input := &secretsmanager.StopReplicationToReplicaInput{
+		SecretId: aws.String(secretName),
+	}
+
+	output, err := client.StopReplicationToReplica(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// tagSecret adds or overwrites tags on the secret in AWS Secrets Manager
+func tagSecret(ctx context.Context, client *secretsmanager.Client, secretName string, tags []types.Tag) (*secretsmanager.TagResourceOutput, error) {
+	input := &secretsmanager.TagResourceInput{
+		SecretId: aws.String(secretName),
+		Tags:     tags,
+	}
+
+	output, err := client.TagResource(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// untagSecret removes tags from the secret in AWS Secrets Manager
+func untagSecret(ctx context.Context, client *secretsmanager.Client, secretName string, tagKeys []string) (*secretsmanager.UntagResourceOutput, error) {
+	input := &secretsmanager.UntagResourceInput{
+		SecretId: aws.String(secretName),
+		TagKeys:  tagKeys,
+	}
+
+	output, err := client.UntagResource(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// describeSecret fetches the secret metadata from AWS Secrets Manager without reading its value
+func describeSecret(ctx context.Context, client *secretsmanager.Client, secretName string) (*secretsmanager.DescribeSecretOutput, error) {
+	input := &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secretName),
+	}
+
+	output, err := client.DescribeSecret(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// updateSecretVersionStage moves a staging label between versions of the secret in AWS Secrets Manager
+func updateSecretVersionStage(ctx context.Context, client *secretsmanager.Client, secretName, stage, moveToVersionId, removeFromVersionId string) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:     aws.String(secretName),
+		VersionStage: aws.String(stage),
+	}
+	if moveToVersionId != "" {
+		input.MoveToVersionId = aws.String(moveToVersionId)
+	}
+	if removeFromVersionId != "" {
+		input.RemoveFromVersionId = aws.String(removeFromVersionId)
+	}
+
+	output, err := client.UpdateSecretVersionStage(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// listSecrets lists the metadata of secrets matching the given options in AWS Secrets Manager
+func listSecrets(ctx context.Context, client *secretsmanager.Client, options common.ListOptions) (*secretsmanager.ListSecretsOutput, error) {
+	input := &secretsmanager.ListSecretsInput{
+		Filters: getListFilters(options),
+	}
+	if options.MaxResults > 0 {
+		input.MaxResults = aws.Int32(int32(options.MaxResults))
+	}
+	if options.NextToken != "" {
+		input.NextToken = aws.String(options.NextToken)
+	}
+
+	output, err := client.ListSecrets(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// getCallerIdentity fetches the identity of the configured credentials from AWS STS
+func getCallerIdentity(ctx context.Context, client *sts.Client) (*sts.GetCallerIdentityOutput, error) {
+	output, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
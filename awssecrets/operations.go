@@ -5,68 +5,115 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
 )
 
 type AWSSecretManager struct {
-	client *secretsmanager.Client
-	config common.SecretManagerConfig
+	client    *secretsmanager.Client
+	awsConfig aws.Config
+	config    common.SecretManagerConfig
 }
 
 func New(config common.SecretManagerConfig) (common.SecretManager, error) {
-	client, err := createAWSClient(config)
+	pooled, err := defaultClientPool.get(context.Background(), config)
 	if err != nil {
 		return nil, err
 	}
-	return &AWSSecretManager{client: client, config: config}, nil
+	return &AWSSecretManager{client: pooled.client, awsConfig: pooled.awsConfig, config: config}, nil
 }
 
 func (sm *AWSSecretManager) Connect(ctx context.Context, name string) (*common.ValidationResponse, error) {
 	logrus.Infof("Received request for validating AWS Secret Manager: %s", name)
-	_, err := getSecret(ctx, sm.client, name)
+	_, err := getSecret(ctx, sm.client, name, secretVersion{})
 	if err != nil {
 		var resourceNotFoundErr *types.ResourceNotFoundException
-		if errors.As(err, &resourceNotFoundErr) {
-			logrus.Info("Successfully validated AWS Secret Manager")
+		if !errors.As(err, &resourceNotFoundErr) {
+			errorType := getErrorType(err)
+			logrus.Errorf("Failed to validate AWS Secret Manager, error %v", err.Error())
 			return &common.ValidationResponse{
-				IsValid: true,
-				Error:   nil,
+				IsValid: false,
+				Error: &common.Error{
+					Type:    errorType,
+					Message: "Failed validating AWS Secret Manager",
+					Reason:  err.Error(),
+				},
 			}, nil
 		}
+	}
 
-		errorType := getErrorType(err)
-		logrus.Errorf("Failed to validate AWS Secret Manager, error %v", err.Error())
-		return &common.ValidationResponse{
-			IsValid: false,
-			Error: &common.Error{
-				Type:    errorType,
-				Message: "Failed validating AWS Secret Manager",
-				Reason:  err.Error(),
-			},
-		}, nil
+	response := &common.ValidationResponse{
+		IsValid:          true,
+		Error:            nil,
+		CredentialExpiry: sm.credentialExpiry(ctx),
+	}
+	if sm.config.CheckPermissions {
+		response.Permissions = checkPermissions(ctx, sm.client, getFullPath(sm.config.Prefix, permissionProbeName()))
+		if inconclusive := getInconclusiveActions(response.Permissions); len(inconclusive) > 0 {
+			logrus.Warnf("Could not determine permissions for AWS Secret Manager: %s", strings.Join(inconclusive, ", "))
+		}
+		if denied := getDeniedActions(response.Permissions); len(denied) > 0 {
+			logrus.Errorf("Missing permissions for AWS Secret Manager: %s", strings.Join(denied, ", "))
+			response.IsValid = false
+			response.Error = &common.Error{
+				Type:    accessDeniedErrorCode,
+				Message: "Missing permissions for AWS Secret Manager",
+				Reason:  fmt.Sprintf("denied actions: %s", strings.Join(denied, ", ")),
+			}
+			return response, nil
+		}
 	}
 	logrus.Info("Successfully validated AWS Secret Manager")
-	return &common.ValidationResponse{
-		IsValid: true,
-		Error:   nil,
-	}, nil
+	return response, nil
+}
+
+// credentialExpiry returns when the current credentials expire, or nil if they don't
+func (sm *AWSSecretManager) credentialExpiry(ctx context.Context) *time.Time {
+	if sm.awsConfig.Credentials == nil {
+		return nil
+	}
+	creds, err := sm.awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		logrus.Warnf("Failed to retrieve AWS credentials expiry: %v", err)
+		return nil
+	}
+	if !creds.CanExpire {
+		return nil
+	}
+	return &creds.Expires
 }
 
 func (sm *AWSSecretManager) FetchSecret(ctx context.Context, secret common.Secret) (*common.SecretResponse, error) {
 	logrus.Infof("Received request for fetching AWS Secret: %s", secret.Name)
-	secretName, jsonKey := extractSecretInfo(secret.Name)
+	secretName, jsonKey, version := extractSecretInfo(secret.Name)
 
-	secretOutput, err := getSecret(ctx, sm.client, secretName)
+	secretOutput, err := getSecret(ctx, sm.client, secretName, getSecretVersion(secret, version))
 	if err != nil {
 		logrus.Errorf("Failed to fetch secret %s, error: %v", secretName, err.Error())
 		return nil, fmt.Errorf("could not find secret key: %s. Failed with error %v", secretName, err.Error())
 	}
 	logrus.Infof("Successfully fetched secret %s", secretName)
-	secretValue := *secretOutput.SecretString
+	secretValue, binary := getSecretValue(secretOutput)
+	if binary {
+		return &common.SecretResponse{
+			Value:       secretValue,
+			ContentType: ContentTypeBinary,
+		}, nil
+	}
 
-	decodedSecretValue, err := decode(secretValue, secret.Base64, secretName)
+	// secrets stored encoded are tagged, so callers don't have to ask for decoding. Versions written
+	// encoded by this handler are also marked in their version ID, which spares reading the tag.
+	shouldDecode := secret.Base64 || isEncodedVersion(aws.ToString(secretOutput.VersionId)) ||
+		(isBase64(secretValue) && isBase64Encoded(ctx, sm.client, secretName))
+	decodedSecretValue, err := decode(secretValue, shouldDecode, secretName)
+	if err != nil {
+		logrus.Errorf("Failed to decode secret %s, error: %v", secretName, err.Error())
+		return nil, err
+	}
 	if !isValidJSON(decodedSecretValue) {
 		return &common.SecretResponse{
 			Value: decodedSecretValue,
@@ -79,11 +126,19 @@ func (sm *AWSSecretManager) FetchSecret(ctx context.Context, secret common.Secre
 }
 
 func (sm *AWSSecretManager) CreateSecret(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
-	fullSecretName := getFullPath(sm.config.Prefix, secret.Name)
+	return sm.createSecretNamed(ctx, secret, getFullPath(sm.config.Prefix, secret.Name))
+}
+
+// createSecretNamed creates the secret under fullSecretName, which already carries the prefix
+func (sm *AWSSecretManager) createSecretNamed(ctx context.Context, secret common.Secret, fullSecretName string) (*common.OperationResponse, error) {
 	secret.Name = fullSecretName
 
+	if secret.ReplicaRegions == nil {
+		secret.ReplicaRegions = sm.config.ReplicaRegions
+	}
+
 	logrus.Infof("Received request for creating AWS Secret: %s", fullSecretName)
-	output, err := createSecret(ctx, sm.client, secret)
+	output, err := createSecret(ctx, sm.client, secret, getTags(getSecretTags(sm.config, secret)))
 	if err != nil {
 		logrus.Errorf("Failed to create secret %s, error: %v", fullSecretName, err.Error())
 		return &common.OperationResponse{
@@ -103,11 +158,17 @@ func (sm *AWSSecretManager) CreateSecret(ctx context.Context, secret common.Secr
 		Message:         "Successfully created secret in AWS Secret Manager",
 		OperationStatus: common.OperationStatusSuccess,
 		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
 	}, nil
 }
 
 func (sm *AWSSecretManager) UpdateSecret(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
-	fullSecretName := getFullPath(sm.config.Prefix, secret.Name)
+	return sm.updateSecretFrom(ctx, secret, getFullPath(sm.config.Prefix, secret.Name), "")
+}
+
+// updateSecretFrom updates the secret fullSecretName whose current version is currentVersionId, if known,
+// so the encoding tag is only touched when the encoding changes
+func (sm *AWSSecretManager) updateSecretFrom(ctx context.Context, secret common.Secret, fullSecretName, currentVersionId string) (*common.OperationResponse, error) {
 	secret.Name = fullSecretName
 
 	logrus.Infof("Received request for updating AWS Secret: %s", fullSecretName)
@@ -125,23 +186,41 @@ func (sm *AWSSecretManager) UpdateSecret(ctx context.Context, secret common.Secr
 		}, nil
 	}
 
+	sm.reconcileTags(ctx, fullSecretName, secret, currentVersionId)
+
 	logrus.Infof("Successfully updated secret %s", fullSecretName)
 	return &common.OperationResponse{
 		Name:            *output.Name,
 		Message:         "Successfully updated secret in AWS Secret Manager",
 		OperationStatus: common.OperationStatusSuccess,
 		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
 	}, nil
 }
 
 func (sm *AWSSecretManager) UpsertSecret(ctx context.Context, secret common.Secret, existingSecret *common.Secret) (*common.OperationResponse, error) {
 	fullSecretName := getFullPath(sm.config.Prefix, secret.Name)
+	// writeName is where the value is written, which moves to the fallback name only once on_pending_deletion acts on it
+	writeName := fullSecretName
 	secretExists := false
+	currentVersionId := ""
 
-	if _, err := fetchSecretInternal(ctx, sm.client, fullSecretName); err != nil {
+	if current, err := fetchSecretInternal(ctx, sm.client, fullSecretName); err != nil {
 		var resourceNotFoundErr *types.ResourceNotFoundException
+		var invalidRequestErr *types.InvalidRequestException
 		if errors.As(err, &resourceNotFoundErr) {
 			logrus.Infof("Resource %s Doesn't exist : %v", fullSecretName, err.Error())
+		} else if errors.As(err, &invalidRequestErr) && isScheduledForDeletion(ctx, sm.client, fullSecretName) {
+			exists, failure := sm.handlePendingDeletion(ctx, fullSecretName, secret.OnPendingDeletion)
+			if failure != nil {
+				return failure, nil
+			}
+			secretExists = exists
+			if exists {
+				if restored, err := fetchSecretInternal(ctx, sm.client, fullSecretName); err == nil {
+					currentVersionId = restored.versionId
+				}
+			}
 		} else {
 			logrus.Warnf("Failed fetching secret %s, error : %v, retrying...", fullSecretName, err.Error())
 			
@@ -150,6 +229,19 @@ func (sm *AWSSecretManager) UpsertSecret(ctx context.Context, secret common.Secr
 			if _, err := fetchSecretInternal(ctx, sm.client, fullSecretName); err != nil {
 				if errors.As(err, &resourceNotFoundErr) {
 					logrus.Infof("Resource %s Doesn't exist : %v", fullSecretName, err.Error())
+				} else if errors.As(err, &invalidRequestErr) && isScheduledForDeletion(ctx, sm.client, fullSecretName) {
+					// the policy acts on the fallback name, so the value must be written there too
+					exists, failure := sm.handlePendingDeletion(ctx, fullSecretName, secret.OnPendingDeletion)
+					if failure != nil {
+						return failure, nil
+					}
+					writeName = fullSecretName
+					secretExists = exists
+					if exists {
+						if restored, err := fetchSecretInternal(ctx, sm.client, fullSecretName); err == nil {
+							currentVersionId = restored.versionId
+						}
+					}
 				} else {
 					logrus.Errorf("Failed fetching secret %s, error : %v", fullSecretName, err.Error())
 					return &common.OperationResponse{
@@ -166,18 +258,42 @@ func (sm *AWSSecretManager) UpsertSecret(ctx context.Context, secret common.Secr
 		}
 	} else {
 		secretExists = true
+		currentVersionId = current.versionId
+	}
+
+	if secret.Generate != nil {
+		logrus.Infof("Generating value for secret %s", fullSecretName)
+		password, err := generatePassword(ctx, sm.client, *secret.Generate)
+		if err != nil {
+			logrus.Errorf("Failed to generate value for secret %s, error: %v", fullSecretName, err.Error())
+			return &common.OperationResponse{
+				Name:            fullSecretName,
+				Message:         "Failed to generate secret value",
+				OperationStatus: common.OperationStatusFailure,
+				Error: &common.Error{
+					Message: "Failed to generate secret value",
+					Reason:  err.Error(),
+				},
+			}, nil
+		}
+		secret.Plaintext = &password
+		secret.Base64Encoded = false
+		secret.Binary = false
 	}
 
 	var err error
 	var response *common.OperationResponse
 	if !secretExists {
-		response, err = sm.CreateSecret(ctx, secret)
+		response, err = sm.createSecretNamed(ctx, secret, writeName)
 	} else {
-		response, err = sm.UpdateSecret(ctx, secret)
+		response, err = sm.updateSecretFrom(ctx, secret, writeName, currentVersionId)
 	}
 	if err != nil {
 		return nil, err
 	}
+	if secret.Generate != nil && secret.Generate.ReturnValue && response.OperationStatus == common.OperationStatusSuccess {
+		response.Value = *secret.Plaintext
+	}
 
 	if existingSecret != nil {
 		oldFullSecretName := existingSecret.Name
@@ -187,7 +303,7 @@ func (sm *AWSSecretManager) UpsertSecret(ctx context.Context, secret common.Secr
 		if oldFullSecretName != "" && oldFullSecretName != fullSecretName {
 			logrus.Infof("Old path of the secret %s is different than the current one %s. Deleting the old secret",
 				oldFullSecretName, fullSecretName)
-			if _, err := deleteSecret(ctx, sm.client, *existingSecret); err != nil {
+			if _, err := deleteSecret(ctx, sm.client, *existingSecret, sm.config.RecoveryWindowDays); err != nil {
 				logrus.Warnf("Old path of the secret %s is different than the current one %s. Failed deleting the old secret. Error: %v",
 					oldFullSecretName, fullSecretName, err.Error())
 			}
@@ -196,11 +312,60 @@ func (sm *AWSSecretManager) UpsertSecret(ctx context.Context, secret common.Secr
 	return response, nil
 }
 
+// GenerateSecret creates or updates the secret with a generated password, by default 32 characters of every type
+func (sm *AWSSecretManager) GenerateSecret(ctx context.Context, secret common.Secret, existingSecret *common.Secret) (*common.OperationResponse, error) {
+	if secret.Generate == nil {
+		secret.Generate = &common.PasswordOptions{}
+	}
+	return sm.UpsertSecret(ctx, secret, existingSecret)
+}
+
+// handlePendingDeletion applies the on_pending_deletion policy to a secret scheduled for deletion
+// and reports whether the secret exists afterwards, or a failure response if the upsert must stop
+func (sm *AWSSecretManager) handlePendingDeletion(ctx context.Context, secretName, policy string) (bool, *common.OperationResponse) {
+	failure := func(reason string) *common.OperationResponse {
+		logrus.Errorf("Secret %s is scheduled for deletion: %s", secretName, reason)
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Secret is scheduled for deletion in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Type:    "SecretScheduledForDeletion",
+				Message: "Secret is scheduled for deletion in AWS Secret Manager",
+				Reason:  reason,
+			},
+		}
+	}
+
+	switch strings.ToLower(policy) {
+	case "", PendingDeletionFail:
+		return false, failure(fmt.Sprintf("restore it first or set on_pending_deletion to %q or %q",
+			PendingDeletionRestore, PendingDeletionRecreate))
+	case PendingDeletionRestore:
+		logrus.Infof("Secret %s is scheduled for deletion, restoring it before updating", secretName)
+		if _, err := restoreSecret(ctx, sm.client, secretName); err != nil {
+			return false, failure(fmt.Sprintf("failed to restore secret: %v", err.Error()))
+		}
+		return true, nil
+	case PendingDeletionRecreate:
+		logrus.Infof("Secret %s is scheduled for deletion, force deleting it before creating", secretName)
+		if _, err := deleteSecret(ctx, sm.client, common.Secret{Name: secretName, ForceDelete: true}, 0); err != nil {
+			return false, failure(fmt.Sprintf("failed to force delete secret: %v", err.Error()))
+		}
+		if err := waitForDeletion(ctx, sm.client, secretName); err != nil {
+			return false, failure(err.Error())
+		}
+		return false, nil
+	default:
+		return false, failure(fmt.Sprintf("unsupported on_pending_deletion policy: %s", policy))
+	}
+}
+
 func (sm *AWSSecretManager) RenameSecret(ctx context.Context, secret common.Secret, existingSecret *common.Secret) (*common.OperationResponse, error) {
 	fullSecretName := getFullPath(sm.config.Prefix, secret.Name)
 	logrus.Infof("Received request for renaming AWS Secret: %s", fullSecretName)
 	//fetch existing record - if not found, nothing to update because we won't know what value to update
-	secretValue, err := fetchSecretInternal(ctx, sm.client, existingSecret.Name)
+	existing, err := fetchSecretInternal(ctx, sm.client, existingSecret.Name)
 	if err != nil {
 		return &common.OperationResponse{
 			Name:            existingSecret.Name,
@@ -213,27 +378,141 @@ func (sm *AWSSecretManager) RenameSecret(ctx context.Context, secret common.Secr
 		}, nil
 	}
 
-	secret.Plaintext = &secretValue
+	secret.Plaintext = &existing.value
+	secret.Binary = existing.binary
+	secret.Generate = nil
+	// the value is copied as stored, so an encoded value keeps its encoding
+	secret.Base64Encoded = true
+	if !existing.binary {
+		existingName, _ := splitSecretKey(existingSecret.Name)
+		secret.Base64 = secret.Base64 || isEncodedVersion(existing.versionId) || isBase64Encoded(ctx, sm.client, existingName)
+	}
 	// upsert with new secret
 	return sm.UpsertSecret(ctx, secret, existingSecret)
 }
 
-func fetchSecretInternal(ctx context.Context, client *secretsmanager.Client, name string) (string, error) {
-	secretName, jsonKey := extractSecretInfo(name)
-	secretOutput, err := getSecret(ctx, client, secretName)
+// reconcileTags brings the tags in line with the update just written: all tags when the secret
+// lists its own, otherwise only the encoding tag. replacedVersionId is the version the update
+// replaced, if known. Failures are only logged, as the value is stored.
+func (sm *AWSSecretManager) reconcileTags(ctx context.Context, secretName string, secret common.Secret, replacedVersionId string) {
+	if secret.Tags == nil {
+		// replacing a version this handler wrote encoded means the tag is already set; in any other
+		// case it may have been set or left by others, and fetch relies on it
+		encoded := isBase64Tagged(secret)
+		if secret.Plaintext != nil && !(encoded && isEncodedVersion(replacedVersionId)) {
+			sm.syncEncodingTag(ctx, secretName, encoded)
+		}
+		return
+	}
+
+	output, err := describeSecret(ctx, sm.client, secretName)
 	if err != nil {
-		return "", err
+		logrus.Warnf("Failed to read tags of secret %s, error: %v", secretName, err.Error())
+		return
+	}
+	currentTags := getTagMap(output.Tags)
+	toSet, toRemove := getTagChanges(currentTags, getUpdatedTags(sm.config, secret, currentTags))
+	if len(toSet) > 0 {
+		if _, err := tagSecret(ctx, sm.client, secretName, getTags(toSet)); err != nil {
+			logrus.Warnf("Failed to tag secret %s, error: %v", secretName, err.Error())
+		}
 	}
-	secretValue := *secretOutput.SecretString
-	if !isValidJSON(secretValue) {
-		return secretValue, nil
+	if len(toRemove) > 0 {
+		if _, err := untagSecret(ctx, sm.client, secretName, toRemove); err != nil {
+			logrus.Warnf("Failed to untag secret %s, error: %v", secretName, err.Error())
+		}
+	}
+}
+
+// syncEncodingTag tags the secret as encoded, or removes the tag, to match its current version.
+// Failures are only logged, as the value is stored.
+func (sm *AWSSecretManager) syncEncodingTag(ctx context.Context, secretName string, encoded bool) {
+	var err error
+	if encoded {
+		_, err = tagSecret(ctx, sm.client, secretName, []types.Tag{{
+			Key:   aws.String(EncodingTagKey),
+			Value: aws.String(EncodingBase64),
+		}})
+	} else {
+		_, err = untagSecret(ctx, sm.client, secretName, []string{EncodingTagKey})
+	}
+	if err != nil {
+		logrus.Warnf("Failed to update encoding tag of secret %s, error: %v", secretName, err.Error())
 	}
-	return getValueFromJSON(secretValue, jsonKey), nil
+}
+
+// isBase64Encoded reports whether the secret is tagged as stored base64 encoded
+func isBase64Encoded(ctx context.Context, client *secretsmanager.Client, name string) bool {
+	output, err := describeSecret(ctx, client, name)
+	if err != nil {
+		logrus.Warnf("Failed to read encoding tag of secret %s, error: %v", name, err.Error())
+		return false
+	}
+	return getTagMap(output.Tags)[EncodingTagKey] == EncodingBase64
+}
+
+// isScheduledForDeletion reports whether the secret exists but is pending deletion
+func isScheduledForDeletion(ctx context.Context, client *secretsmanager.Client, name string) bool {
+	output, err := describeSecret(ctx, client, name)
+	return err == nil && output.DeletedDate != nil
+}
+
+// waitForDeletion polls until a force deleted secret is gone, as deletion completes asynchronously
+func waitForDeletion(ctx context.Context, client *secretsmanager.Client, name string) error {
+	for i := 0; i < deletionWaitAttempts; i++ {
+		_, err := describeSecret(ctx, client, name)
+		if err != nil {
+			var resourceNotFoundErr *types.ResourceNotFoundException
+			if errors.As(err, &resourceNotFoundErr) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(deletionWaitInterval):
+		}
+	}
+	return fmt.Errorf("secret %s still exists after force delete", name)
+}
+
+// fetchedSecret is a secret value as stored; binary values are base64 encoded
+type fetchedSecret struct {
+	value     string
+	binary    bool
+	versionId string
+}
+
+// fetchSecretInternal returns the current value of the named secret, taking the name literally apart
+// from an optional #key
+func fetchSecretInternal(ctx context.Context, client *secretsmanager.Client, name string) (*fetchedSecret, error) {
+	secretName, jsonKey := splitSecretKey(name)
+	return fetchSecretVersion(ctx, client, secretName, jsonKey, secretVersion{})
+}
+
+// fetchSecretReference returns the value a reference points to, which may pin a version with "@"
+func fetchSecretReference(ctx context.Context, client *secretsmanager.Client, reference string) (*fetchedSecret, error) {
+	secretName, jsonKey, version := extractSecretInfo(reference)
+	return fetchSecretVersion(ctx, client, secretName, jsonKey, version)
+}
+
+func fetchSecretVersion(ctx context.Context, client *secretsmanager.Client, secretName, jsonKey string, version secretVersion) (*fetchedSecret, error) {
+	secretOutput, err := getSecret(ctx, client, secretName, version)
+	if err != nil {
+		return nil, err
+	}
+	fetched := &fetchedSecret{versionId: aws.ToString(secretOutput.VersionId)}
+	fetched.value, fetched.binary = getSecretValue(secretOutput)
+	if !fetched.binary && isValidJSON(fetched.value) {
+		fetched.value = getValueFromJSON(fetched.value, jsonKey)
+	}
+	return fetched, nil
 }
 
 func (sm *AWSSecretManager) ValidateReference(ctx context.Context, name string) (*common.ValidationResponse, error) {
 	logrus.Infof("Received request for validating AWS Secret reference: %s", name)
-	_, err := fetchSecretInternal(ctx, sm.client, name)
+	_, err := fetchSecretReference(ctx, sm.client, name)
 
 	if err != nil {
 		logrus.Errorf("Failed to validate AWS Secret reference, error %v", err.Error())
@@ -255,7 +534,7 @@ func (sm *AWSSecretManager) ValidateReference(ctx context.Context, name string)
 func (sm *AWSSecretManager) DeleteSecret(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
 	secretName := secret.Name
 	logrus.Infof("Received request for deleting AWS Secret: %s", secretName)
-	output, err := deleteSecret(ctx, sm.client, secret)
+	output, err := deleteSecret(ctx, sm.client, secret, sm.config.RecoveryWindowDays)
 	if err != nil {
 		logrus.Errorf("Failed to delete secret %s, error: %v", secretName, err.Error())
 		return &common.OperationResponse{
@@ -269,11 +548,452 @@ func (sm *AWSSecretManager) DeleteSecret(ctx context.Context, secret common.Secr
 		}, nil
 	}
 
-	logrus.Infof("Successfully deleted secret %s", secretName)
+	message := "Successfully deleted secret in AWS Secret Manager"
+	if !secret.ForceDelete {
+		message = "Successfully scheduled secret for deletion in AWS Secret Manager"
+	}
+	logrus.Infof("Successfully deleted secret %s, deletion date: %v", secretName, aws.ToTime(output.DeletionDate))
+	return &common.OperationResponse{
+		Name:            *output.Name,
+		Message:         message,
+		OperationStatus: common.OperationStatusSuccess,
+		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
+		DeletionDate:    output.DeletionDate,
+	}, nil
+}
+
+func (sm *AWSSecretManager) RestoreSecret(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	logrus.Infof("Received request for restoring AWS Secret: %s", secretName)
+	output, err := restoreSecret(ctx, sm.client, secretName)
+	if err != nil {
+		logrus.Errorf("Failed to restore secret %s, error: %v", secretName, err.Error())
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to restore secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to restore secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully restored secret %s", secretName)
+	return &common.OperationResponse{
+		Name:            *output.Name,
+		Message:         "Successfully restored secret in AWS Secret Manager",
+		OperationStatus: common.OperationStatusSuccess,
+		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
+	}, nil
+}
+
+func (sm *AWSSecretManager) WhoAmI(ctx context.Context) (*common.IdentityResponse, error) {
+	logrus.Info("Received request for resolving AWS identity")
+	credentialSource := getCredentialSource(sm.config)
+	output, err := getCallerIdentity(ctx, newSTSClient(sm.awsConfig, sm.config))
+	if err != nil {
+		logrus.Errorf("Failed to resolve AWS identity, error: %v", err.Error())
+		return &common.IdentityResponse{
+			CredentialSource: credentialSource,
+			Error: &common.Error{
+				Type:    getErrorType(err),
+				Message: "Failed to resolve AWS identity",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully resolved AWS identity %s", aws.ToString(output.Arn))
+	return &common.IdentityResponse{
+		Account:          aws.ToString(output.Account),
+		Arn:              aws.ToString(output.Arn),
+		UserId:           aws.ToString(output.UserId),
+		CredentialSource: credentialSource,
+		CredentialExpiry: sm.credentialExpiry(ctx),
+		Error:            nil,
+	}, nil
+}
+
+func (sm *AWSSecretManager) ListSecrets(ctx context.Context, options common.ListOptions) (*common.ListSecretsResponse, error) {
+	if options.NamePrefix == "" {
+		options.NamePrefix = getFullPath(sm.config.Prefix, "")
+	}
+	logrus.Infof("Received request for listing AWS Secrets with prefix: %s", options.NamePrefix)
+	output, err := listSecrets(ctx, sm.client, options)
+	if err != nil {
+		logrus.Errorf("Failed to list secrets with prefix %s, error: %v", options.NamePrefix, err.Error())
+		return &common.ListSecretsResponse{
+			Error: &common.Error{
+				Type:    getErrorType(err),
+				Message: "Failed to list secrets in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	secrets := make([]common.SecretMetadata, 0, len(output.SecretList))
+	for _, entry := range output.SecretList {
+		secrets = append(secrets, getSecretMetadata(entry))
+	}
+	logrus.Infof("Successfully listed %d secrets with prefix %s", len(secrets), options.NamePrefix)
+	return &common.ListSecretsResponse{
+		Secrets:   secrets,
+		NextToken: aws.ToString(output.NextToken),
+		Error:     nil,
+	}, nil
+}
+
+func (sm *AWSSecretManager) DescribeSecret(ctx context.Context, secret common.Secret) (*common.DescribeSecretResponse, error) {
+	secretName, _ := splitSecretKey(secret.Name)
+	logrus.Infof("Received request for describing AWS Secret: %s", secretName)
+	output, err := describeSecret(ctx, sm.client, secretName)
+	if err != nil {
+		logrus.Errorf("Failed to describe secret %s, error: %v", secretName, err.Error())
+		return &common.DescribeSecretResponse{
+			SecretMetadata: common.SecretMetadata{Name: secretName},
+			Error: &common.Error{
+				Type:    getErrorType(err),
+				Message: "Failed to describe secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully described secret %s", secretName)
+	return getSecretDescription(output), nil
+}
+
+// RollbackSecret makes AWSPREVIOUS, or the version pinned on the secret, current again
+func (sm *AWSSecretManager) RollbackSecret(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
+	secretName, _, version := extractSecretInfo(secret.Name)
+	version = getSecretVersion(secret, version)
+	logrus.Infof("Received request for rolling back AWS Secret: %s", secretName)
+
+	failure := func(reason string) *common.OperationResponse {
+		logrus.Errorf("Failed to roll back secret %s, error: %v", secretName, reason)
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to roll back secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to roll back secret in AWS Secret Manager",
+				Reason:  reason,
+			},
+		}
+	}
+
+	description, err := describeSecret(ctx, sm.client, secretName)
+	if err != nil {
+		return failure(err.Error()), nil
+	}
+
+	currentVersionId := getVersionIdForStage(description.VersionIdsToStages, StageCurrent)
+	targetVersionId := version.versionId
+	if targetVersionId == "" {
+		stage := version.versionStage
+		if stage == "" {
+			stage = StagePrevious
+		}
+		if targetVersionId = getVersionIdForStage(description.VersionIdsToStages, stage); targetVersionId == "" {
+			return failure(fmt.Sprintf("no version of the secret is labelled %s", stage)), nil
+		}
+	}
+
+	if targetVersionId == currentVersionId {
+		logrus.Infof("Version %s of secret %s is already current", targetVersionId, secretName)
+		return &common.OperationResponse{
+			Name:            aws.ToString(description.Name),
+			Message:         "Secret version is already current in AWS Secret Manager",
+			OperationStatus: common.OperationStatusSuccess,
+			Error:           nil,
+			VersionId:       targetVersionId,
+		}, nil
+	}
+
+	// AWS moves AWSPREVIOUS onto the version losing AWSCURRENT
+	output, err := updateSecretVersionStage(ctx, sm.client, secretName, StageCurrent, targetVersionId, currentVersionId)
+	if err != nil {
+		return failure(err.Error()), nil
+	}
+	// a version this handler wrote encoded needs the tag, and rolling back from one to an unmarked version
+	// drops the tag it set; between unmarked versions the tag is left as it is
+	tagged := getTagMap(description.Tags)[EncodingTagKey] == EncodingBase64
+	if isEncodedVersion(targetVersionId) && !tagged {
+		sm.syncEncodingTag(ctx, secretName, true)
+	} else if !isEncodedVersion(targetVersionId) && isEncodedVersion(currentVersionId) && tagged {
+		sm.syncEncodingTag(ctx, secretName, false)
+	}
+
+	logrus.Infof("Successfully rolled back secret %s from version %s to %s", secretName, currentVersionId, targetVersionId)
+	return &common.OperationResponse{
+		Name:              aws.ToString(output.Name),
+		Message:           "Successfully rolled back secret in AWS Secret Manager",
+		OperationStatus:   common.OperationStatusSuccess,
+		Error:             nil,
+		Retries:           getRetryCount(output.ResultMetadata),
+		VersionId:         targetVersionId,
+		PreviousVersionId: currentVersionId,
+	}, nil
+}
+
+// RotateSecret rotates the secret now, using its existing rotation configuration
+func (sm *AWSSecretManager) RotateSecret(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	logrus.Infof("Received request for rotating AWS Secret: %s", secretName)
+	output, err := rotateSecret(ctx, sm.client, secretName, nil)
+	if err != nil {
+		logrus.Errorf("Failed to rotate secret %s, error: %v", secretName, err.Error())
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to rotate secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to rotate secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully started rotation of secret %s, version %s", secretName, aws.ToString(output.VersionId))
+	return &common.OperationResponse{
+		Name:             *output.Name,
+		Message:          "Successfully started rotation of secret in AWS Secret Manager",
+		OperationStatus:  common.OperationStatusSuccess,
+		Error:            nil,
+		Retries:          getRetryCount(output.ResultMetadata),
+		VersionId:        aws.ToString(output.VersionId),
+		NextRotationDate: getNextRotationDate(ctx, sm.client, secretName),
+	}, nil
+}
+
+// ConfigureRotation sets the rotation Lambda and schedule, rotating immediately only if asked to
+func (sm *AWSSecretManager) ConfigureRotation(ctx context.Context, secret common.Secret, options common.RotationOptions) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	logrus.Infof("Received request for configuring rotation of AWS Secret: %s", secretName)
+	output, err := rotateSecret(ctx, sm.client, secretName, &options)
+	if err != nil {
+		logrus.Errorf("Failed to configure rotation of secret %s, error: %v", secretName, err.Error())
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to configure rotation of secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to configure rotation of secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully configured rotation of secret %s", secretName)
+	return &common.OperationResponse{
+		Name:             *output.Name,
+		Message:          "Successfully configured rotation of secret in AWS Secret Manager",
+		OperationStatus:  common.OperationStatusSuccess,
+		Error:            nil,
+		Retries:          getRetryCount(output.ResultMetadata),
+		VersionId:        aws.ToString(output.VersionId),
+		NextRotationDate: getNextRotationDate(ctx, sm.client, secretName),
+	}, nil
+}
+
+func (sm *AWSSecretManager) CancelRotation(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	logrus.Infof("Received request for cancelling rotation of AWS Secret: %s", secretName)
+	output, err := cancelRotateSecret(ctx, sm.client, secretName)
+	if err != nil {
+		logrus.Errorf("Failed to cancel rotation of secret %s, error: %v", secretName, err.Error())
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to cancel rotation of secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to cancel rotation of secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully cancelled rotation of secret %s", secretName)
 	return &common.OperationResponse{
 		Name:            *output.Name,
-		Message:         "Successfully deleted secret in AWS Secret Manager",
+		Message:         "Successfully cancelled rotation of secret in AWS Secret Manager",
+		OperationStatus: common.OperationStatusSuccess,
+		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
+		VersionId:       aws.ToString(output.VersionId),
+	}, nil
+}
+
+// RotateSecretLocally rotates the secret from the runner with a registered Rotator instead of a Lambda
+func (sm *AWSSecretManager) RotateSecretLocally(ctx context.Context, secret common.Secret, options common.RotationOptions) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	logrus.Infof("Received request for locally rotating AWS Secret: %s with rotator %s", secretName, options.Rotator)
+
+	var err error
+	var result *RotationResult
+	if rotator, ok := getRotator(options.Rotator); !ok {
+		err = fmt.Errorf("no rotator registered with name %q", options.Rotator)
+	} else {
+		// the password rotator takes its rules from the request
+		if passwordRotator, ok := rotator.(PasswordRotator); ok && options.Generate != nil {
+			passwordRotator.Options = *options.Generate
+			rotator = passwordRotator
+		}
+		result, err = NewRotationEngine(sm.client, rotator).Rotate(ctx, secretName)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to rotate secret %s locally, error: %v", secretName, err.Error())
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to rotate secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to rotate secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	return &common.OperationResponse{
+		Name:              secretName,
+		Message:           "Successfully rotated secret in AWS Secret Manager",
+		OperationStatus:   common.OperationStatusSuccess,
+		Error:             nil,
+		VersionId:         result.VersionId,
+		PreviousVersionId: result.PreviousVersionId,
+	}, nil
+}
+
+// getNextRotationDate reads the next scheduled rotation; it is informational, so failures only log
+func getNextRotationDate(ctx context.Context, client *secretsmanager.Client, secretName string) *time.Time {
+	output, err := describeSecret(ctx, client, secretName)
+	if err != nil {
+		logrus.Warnf("Failed to read next rotation date of secret %s, error: %v", secretName, err.Error())
+		return nil
+	}
+	return output.NextRotationDate
+}
+
+func (sm *AWSSecretManager) ReplicateSecret(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	regions := secret.ReplicaRegions
+	if regions == nil {
+		regions = sm.config.ReplicaRegions
+	}
+	logrus.Infof("Received request for replicating AWS Secret: %s to %d regions", secretName, len(regions))
+
+	var err error
+	var output *secretsmanager.ReplicateSecretToRegionsOutput
+	if len(regions) == 0 {
+		err = fmt.Errorf("no replica regions given")
+	} else {
+		output, err = replicateSecret(ctx, sm.client, secretName, regions)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to replicate secret %s, error: %v", secretName, err.Error())
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to replicate secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to replicate secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully replicated secret %s", secretName)
+	return &common.OperationResponse{
+		Name:            secretName,
+		Message:         "Successfully replicated secret in AWS Secret Manager",
+		OperationStatus: common.OperationStatusSuccess,
+		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
+		Replication:     getReplicationStatus(output.ReplicationStatus),
+	}, nil
+}
+
+func (sm *AWSSecretManager) RemoveReplica(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	logrus.Infof("Received request for removing replicas of AWS Secret: %s", secretName)
+
+	var err error
+	var output *secretsmanager.RemoveRegionsFromReplicationOutput
+	if len(secret.ReplicaRegions) == 0 {
+		err = fmt.Errorf("no replica regions given")
+	} else {
+		output, err = removeReplicaRegions(ctx, sm.client, secretName, secret.ReplicaRegions)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to remove replicas of secret %s, error: %v", secretName, err.Error())
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to remove replicas of secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to remove replicas of secret in AWS Secret Manager",
+				Reason:  err.Error(),
+			},
+		}, nil
+	}
+
+	logrus.Infof("Successfully removed replicas of secret %s", secretName)
+	return &common.OperationResponse{
+		Name:            secretName,
+		Message:         "Successfully removed replicas of secret in AWS Secret Manager",
+		OperationStatus: common.OperationStatusSuccess,
+		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
+		Replication:     getReplicationStatus(output.ReplicationStatus),
+	}, nil
+}
+
+// PromoteReplica turns the replica into a standalone secret. The call is made in the single
+// replica region given on the secret, or in the store config region if none is given.
+func (sm *AWSSecretManager) PromoteReplica(ctx context.Context, secret common.Secret) (*common.OperationResponse, error) {
+	secretName := secret.Name
+	logrus.Infof("Received request for promoting replica of AWS Secret: %s", secretName)
+
+	failure := func(reason string) *common.OperationResponse {
+		logrus.Errorf("Failed to promote replica of secret %s, error: %v", secretName, reason)
+		return &common.OperationResponse{
+			Name:            secretName,
+			Message:         "Failed to promote replica of secret in AWS Secret Manager",
+			OperationStatus: common.OperationStatusFailure,
+			Error: &common.Error{
+				Message: "Failed to promote replica of secret in AWS Secret Manager",
+				Reason:  reason,
+			},
+		}
+	}
+
+	// the replica is promoted from its own region, which the primary region's client can't reach
+	switch len(secret.ReplicaRegions) {
+	case 0:
+		return failure("the replica region to promote must be given in replica_regions"), nil
+	case 1:
+	default:
+		return failure("only one replica region can be promoted at a time"), nil
+	}
+	pooled, err := defaultClientPool.get(ctx, getReplicaConfig(sm.config, secret.ReplicaRegions[0].Region))
+	if err != nil {
+		return failure(err.Error()), nil
+	}
+
+	output, err := stopReplicationToReplica(ctx, pooled.client, secretName)
+	if err != nil {
+		return failure(err.Error()), nil
+	}
+
+	logrus.Infof("Successfully promoted replica of secret %s", secretName)
+	return &common.OperationResponse{
+		Name:            secretName,
+		Message:         "Successfully promoted replica of secret in AWS Secret Manager",
 		OperationStatus: common.OperationStatusSuccess,
 		Error:           nil,
+		Retries:         getRetryCount(output.ResultMetadata),
 	}, nil
 }
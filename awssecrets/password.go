@@ -0,0 +1,140 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"math/big"
+	"strings"
+)
+
+const DefaultPasswordLength = 32
+
+// character classes used by the local generator, matching those of GetRandomPassword
+const (
+	lowercaseCharacters   = "abcdefghijklmnopqrstuvwxyz"
+	uppercaseCharacters   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numberCharacters      = "0123456789"
+	punctuationCharacters = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+	spaceCharacters       = " "
+)
+
+// generatePassword builds a password with GetRandomPassword, or locally with crypto/rand if asked to
+func generatePassword(ctx context.Context, client *secretsmanager.Client, options common.PasswordOptions) (string, error) {
+	if options.Local {
+		return generateLocalPassword(options)
+	}
+
+	output, err := client.GetRandomPassword(ctx, &secretsmanager.GetRandomPasswordInput{
+		PasswordLength:          aws.Int64(int64(getPasswordLength(options))),
+		ExcludeCharacters:       aws.String(options.ExcludeCharacters),
+		ExcludeLowercase:        aws.Bool(options.ExcludeLowercase),
+		ExcludeUppercase:        aws.Bool(options.ExcludeUppercase),
+		ExcludeNumbers:          aws.Bool(options.ExcludeNumbers),
+		ExcludePunctuation:      aws.Bool(options.ExcludePunctuation),
+		IncludeSpace:            aws.Bool(options.IncludeSpace),
+		RequireEachIncludedType: aws.Bool(requireEachIncludedType(options)),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.RandomPassword), nil
+}
+
+// generateLocalPassword builds a password from crypto/rand following the same rules as GetRandomPassword
+func generateLocalPassword(options common.PasswordOptions) (string, error) {
+	length := getPasswordLength(options)
+
+	var classes []string
+	addClass := func(characters string, exclude bool) {
+		if exclude {
+			return
+		}
+		characters = removeCharacters(characters, options.ExcludeCharacters)
+		if characters != "" {
+			classes = append(classes, characters)
+		}
+	}
+	addClass(lowercaseCharacters, options.ExcludeLowercase)
+	addClass(uppercaseCharacters, options.ExcludeUppercase)
+	addClass(numberCharacters, options.ExcludeNumbers)
+	addClass(punctuationCharacters, options.ExcludePunctuation)
+	addClass(spaceCharacters, !options.IncludeSpace)
+
+	if len(classes) == 0 {
+		return "", fmt.Errorf("password options exclude every character")
+	}
+	requireEach := requireEachIncludedType(options)
+	if requireEach && length < len(classes) {
+		return "", fmt.Errorf("password length %d is too short to include each of %d character types", length, len(classes))
+	}
+
+	password := make([]byte, 0, length)
+	if requireEach {
+		for _, characters := range classes {
+			c, err := randomCharacter(characters)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+	}
+	all := strings.Join(classes, "")
+	for len(password) < length {
+		c, err := randomCharacter(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// shuffle so the required characters don't always lead
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+func getPasswordLength(options common.PasswordOptions) int {
+	if options.Length > 0 {
+		return options.Length
+	}
+	return DefaultPasswordLength
+}
+
+// requireEachIncludedType defaults to true, as it does for GetRandomPassword
+func requireEachIncludedType(options common.PasswordOptions) bool {
+	return options.RequireEachIncludedType == nil || *options.RequireEachIncludedType
+}
+
+func removeCharacters(characters, exclude string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(exclude, r) {
+			return -1
+		}
+		return r
+	}, characters)
+}
+
+func randomCharacter(characters string) (byte, error) {
+	i, err := randomInt(len(characters))
+	if err != nil {
+		return 0, err
+	}
+	return characters[i], nil
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random number: %w", err)
+	}
+	return int(i.Int64()), nil
+}
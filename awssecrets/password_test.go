@@ -0,0 +1,114 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"strings"
+	"testing"
+)
+
+func TestGenerateLocalPassword(t *testing.T) {
+	no := false
+	tests := []struct {
+		name    string
+		options common.PasswordOptions
+		// wantLength is the expected length, allowed the characters that may appear and
+		// required the character sets each of which must appear at least once
+		wantLength int
+		allowed    string
+		required   []string
+		wantErr    string
+	}{
+		{
+			name:       "defaults",
+			options:    common.PasswordOptions{},
+			wantLength: DefaultPasswordLength,
+			allowed:    lowercaseCharacters + uppercaseCharacters + numberCharacters + punctuationCharacters,
+			required:   []string{lowercaseCharacters, uppercaseCharacters, numberCharacters, punctuationCharacters},
+		},
+		{
+			name:       "length",
+			options:    common.PasswordOptions{Length: 8},
+			wantLength: 8,
+			allowed:    lowercaseCharacters + uppercaseCharacters + numberCharacters + punctuationCharacters,
+			required:   []string{lowercaseCharacters, uppercaseCharacters, numberCharacters, punctuationCharacters},
+		},
+		{
+			name:       "numbers only",
+			options:    common.PasswordOptions{Length: 12, ExcludeLowercase: true, ExcludeUppercase: true, ExcludePunctuation: true},
+			wantLength: 12,
+			allowed:    numberCharacters,
+			required:   []string{numberCharacters},
+		},
+		{
+			name:       "include space",
+			options:    common.PasswordOptions{Length: 6, ExcludeLowercase: true, ExcludeUppercase: true, ExcludePunctuation: true, IncludeSpace: true},
+			wantLength: 6,
+			allowed:    numberCharacters + spaceCharacters,
+			required:   []string{numberCharacters, spaceCharacters},
+		},
+		{
+			name:       "excluded characters",
+			options:    common.PasswordOptions{Length: 64, ExcludeCharacters: "0123456789abcdef", ExcludeUppercase: true, ExcludePunctuation: true},
+			wantLength: 64,
+			allowed:    "ghijklmnopqrstuvwxyz",
+			required:   []string{"ghijklmnopqrstuvwxyz"},
+		},
+		{
+			// a class whose characters are all excluded no longer counts as included
+			name:       "class emptied by excluded characters",
+			options:    common.PasswordOptions{Length: 3, ExcludeCharacters: numberCharacters, ExcludeLowercase: true, ExcludePunctuation: true},
+			wantLength: 3,
+			allowed:    uppercaseCharacters,
+			required:   []string{uppercaseCharacters},
+		},
+		{
+			name:       "require each included type off",
+			options:    common.PasswordOptions{Length: 2, RequireEachIncludedType: &no},
+			wantLength: 2,
+			allowed:    lowercaseCharacters + uppercaseCharacters + numberCharacters + punctuationCharacters,
+		},
+		{
+			name:    "too short for each type",
+			options: common.PasswordOptions{Length: 3},
+			wantErr: "too short",
+		},
+		{
+			name:    "every class excluded",
+			options: common.PasswordOptions{ExcludeLowercase: true, ExcludeUppercase: true, ExcludeNumbers: true, ExcludePunctuation: true},
+			wantErr: "exclude every character",
+		},
+		{
+			name:    "every character excluded",
+			options: common.PasswordOptions{ExcludeCharacters: lowercaseCharacters + uppercaseCharacters + numberCharacters + punctuationCharacters},
+			wantErr: "exclude every character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// passwords are random, so check the rules hold across several of them
+			for i := 0; i < 20; i++ {
+				password, err := generateLocalPassword(tt.options)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("generateLocalPassword() error = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("generateLocalPassword() error = %v", err)
+				}
+				if len(password) != tt.wantLength {
+					t.Errorf("generateLocalPassword() = %q, want length %d", password, tt.wantLength)
+				}
+				if j := strings.IndexFunc(password, func(r rune) bool { return !strings.ContainsRune(tt.allowed, r) }); j >= 0 {
+					t.Errorf("generateLocalPassword() = %q, contains disallowed character %q", password, password[j])
+				}
+				for _, characters := range tt.required {
+					if !strings.ContainsAny(password, characters) {
+						t.Errorf("generateLocalPassword() = %q, want a character from %q", password, characters)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const accessDeniedErrorCode = "AccessDeniedException"
+
+// permissionProbe exercises a single action against a secret that does not exist. Only a
+// ResourceNotFoundException for the random name proves the call got past authorization, so every
+// probe is a valid request for that name and nothing exists for it to change.
+type permissionProbe struct {
+	action string
+	probe  func(ctx context.Context, client *secretsmanager.Client, name string) error
+}
+
+// permissionProbes cover the actions fetch, create, update, delete and tag reconciliation call
+var permissionProbes = []permissionProbe{
+	{
+		action: "secretsmanager:DescribeSecret",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+			return err
+		},
+	},
+	{
+		action: "secretsmanager:GetSecretValue",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+			return err
+		},
+	},
+	{
+		// a valid request would create the secret, so this one is invalid and can only show a denial
+		action: "secretsmanager:CreateSecret",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+				Name:         aws.String(name),
+				SecretString: aws.String("probe"),
+				SecretBinary: []byte("probe"),
+			})
+			return err
+		},
+	},
+	{
+		action: "secretsmanager:UpdateSecret",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.UpdateSecret(ctx, &secretsmanager.UpdateSecretInput{
+				SecretId:     aws.String(name),
+				SecretString: aws.String("probe"),
+			})
+			return err
+		},
+	},
+	{
+		action: "secretsmanager:TagResource",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.TagResource(ctx, &secretsmanager.TagResourceInput{
+				SecretId: aws.String(name),
+				Tags:     []types.Tag{{Key: aws.String("probe"), Value: aws.String("probe")}},
+			})
+			return err
+		},
+	},
+	{
+		action: "secretsmanager:UntagResource",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.UntagResource(ctx, &secretsmanager.UntagResourceInput{
+				SecretId: aws.String(name),
+				TagKeys:  []string{"probe"},
+			})
+			return err
+		},
+	},
+	{
+		action: "secretsmanager:RestoreSecret",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.RestoreSecret(ctx, &secretsmanager.RestoreSecretInput{SecretId: aws.String(name)})
+			return err
+		},
+	},
+	{
+		// without force delete, as force deleting a secret that doesn't exist succeeds
+		action: "secretsmanager:DeleteSecret",
+		probe: func(ctx context.Context, client *secretsmanager.Client, name string) error {
+			_, err := client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+				SecretId:             aws.String(name),
+				RecoveryWindowInDays: aws.Int64(MinRecoveryWindowDays),
+			})
+			return err
+		},
+	},
+}
+
+// permissionProbeName returns a name that won't collide with an existing secret
+func permissionProbeName() string {
+	return "permission-probe-" + uuid.New().String()
+}
+
+// checkPermissions runs every probe against the given secret name and reports which actions are allowed
+func checkPermissions(ctx context.Context, client *secretsmanager.Client, name string) []common.PermissionCheck {
+	logrus.Infof("Checking AWS Secret Manager permissions using %s", name)
+	checks := make([]common.PermissionCheck, 0, len(permissionProbes))
+	for _, p := range permissionProbes {
+		checks = append(checks, getPermissionCheck(p.action, p.probe(ctx, client, name)))
+	}
+	return checks
+}
+
+// getPermissionCheck classifies a probe result. Access denied is a denial and not found for the
+// probe name shows authorization passed; any other outcome is inconclusive, as AWS may have
+// rejected the request before checking authorization.
+func getPermissionCheck(action string, err error) common.PermissionCheck {
+	var resourceNotFoundErr *types.ResourceNotFoundException
+	if err == nil || errors.As(err, &resourceNotFoundErr) {
+		return common.PermissionCheck{Action: action, Allowed: true}
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && strings.HasPrefix(apiErr.ErrorCode(), "AccessDenied") {
+		return common.PermissionCheck{Action: action, Allowed: false, Reason: apiErr.ErrorMessage()}
+	}
+	return common.PermissionCheck{Action: action, Allowed: false, Inconclusive: true, Reason: err.Error()}
+}
+
+// getDeniedActions returns the actions that were denied, leaving out inconclusive checks
+func getDeniedActions(checks []common.PermissionCheck) []string {
+	var denied []string
+	for _, check := range checks {
+		if !check.Allowed && !check.Inconclusive {
+			denied = append(denied, check.Action)
+		}
+	}
+	return denied
+}
+
+// getInconclusiveActions returns the actions whose probe could not show whether they are allowed
+func getInconclusiveActions(checks []common.PermissionCheck) []string {
+	var inconclusive []string
+	for _, check := range checks {
+		if check.Inconclusive {
+			inconclusive = append(inconclusive, check.Action)
+		}
+	}
+	return inconclusive
+}
@@ -0,0 +1,52 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	"reflect"
+	"testing"
+)
+
+func TestGetPermissionCheck(t *testing.T) {
+	tests := []struct {
+		name             string
+		err              error
+		wantAllowed      bool
+		wantInconclusive bool
+	}{
+		{name: "success", err: nil, wantAllowed: true},
+		{name: "not found", err: &types.ResourceNotFoundException{Message: aws.String("not found")}, wantAllowed: true},
+		{name: "wrapped not found", err: fmt.Errorf("operation error: %w", &types.ResourceNotFoundException{}), wantAllowed: true},
+		{name: "access denied", err: &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "denied"}},
+		{name: "invalid parameter", err: &types.InvalidParameterException{}, wantInconclusive: true},
+		{name: "invalid request", err: &types.InvalidRequestException{}, wantInconclusive: true},
+		{name: "network error", err: errors.New("dial tcp: connection refused"), wantInconclusive: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getPermissionCheck("secretsmanager:Action", tt.err)
+			if got.Allowed != tt.wantAllowed || got.Inconclusive != tt.wantInconclusive {
+				t.Errorf("getPermissionCheck(%v) = allowed %v, inconclusive %v, want allowed %v, inconclusive %v",
+					tt.err, got.Allowed, got.Inconclusive, tt.wantAllowed, tt.wantInconclusive)
+			}
+		})
+	}
+}
+
+func TestGetDeniedActions(t *testing.T) {
+	checks := []common.PermissionCheck{
+		{Action: "secretsmanager:GetSecretValue", Allowed: true},
+		{Action: "secretsmanager:CreateSecret", Inconclusive: true},
+		{Action: "secretsmanager:DeleteSecret"},
+	}
+	if got, want := getDeniedActions(checks), []string{"secretsmanager:DeleteSecret"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getDeniedActions() = %v, want %v", got, want)
+	}
+	if got, want := getInconclusiveActions(checks), []string{"secretsmanager:CreateSecret"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getInconclusiveActions() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// maxClientAge bounds how long a client is reused when its credentials never expire
+	maxClientAge = 30 * time.Minute
+	// credentialExpiryWindow evicts clients this long before their credentials expire
+	credentialExpiryWindow = 5 * time.Minute
+)
+
+type pooledClient struct {
+	client    *secretsmanager.Client
+	awsConfig aws.Config
+	expiresAt time.Time
+}
+
+// clientPool reuses AWS clients across requests with the same store configuration,
+// so long-lived modes skip config loading and role assumption on repeated calls
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+}
+
+var defaultClientPool = newClientPool()
+
+func newClientPool() *clientPool {
+	return &clientPool{clients: make(map[string]*pooledClient)}
+}
+
+// get returns a pooled client for the given configuration, creating one if none is cached or it has expired
+func (p *clientPool) get(ctx context.Context, secretManagerConfig common.SecretManagerConfig) (*pooledClient, error) {
+	key, err := configKey(secretManagerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	if pooled, ok := p.clients[key]; ok && now.Before(pooled.expiresAt) {
+		p.mu.Unlock()
+		logrus.Debug("Reusing pooled AWS client")
+		return pooled, nil
+	}
+	p.mu.Unlock()
+
+	client, awsConfig, err := createAWSClient(secretManagerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	pooled := &pooledClient{client: client, awsConfig: awsConfig}
+	pooled.expiresAt, err = clientExpiry(ctx, awsConfig, now)
+	if err != nil {
+		// leave the error to surface on the actual operation, but don't cache a client we couldn't verify
+		logrus.Warnf("Failed to retrieve AWS credentials, not pooling client: %v", err)
+		return pooled, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, pooled := range p.clients {
+		if !now.Before(pooled.expiresAt) {
+			delete(p.clients, k)
+		}
+	}
+	p.clients[key] = pooled
+	return pooled, nil
+}
+
+// clientExpiry aligns the pooled client lifetime with the lifetime of its credentials
+func clientExpiry(ctx context.Context, awsConfig aws.Config, now time.Time) (time.Time, error) {
+	expiresAt := now.Add(maxClientAge)
+	if awsConfig.Credentials == nil {
+		return expiresAt, nil
+	}
+	creds, err := awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if creds.CanExpire {
+		if credsExpiresAt := creds.Expires.Add(-credentialExpiryWindow); credsExpiresAt.Before(expiresAt) {
+			expiresAt = credsExpiresAt
+		}
+	}
+	return expiresAt, nil
+}
+
+// configKey hashes the store configuration so secrets in it are not kept as map keys
+func configKey(secretManagerConfig common.SecretManagerConfig) (string, error) {
+	b, err := json.Marshal(secretManagerConfig)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
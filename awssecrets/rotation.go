@@ -0,0 +1,258 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// Rotator produces and rolls out new secret values for the local rotation engine.
+// Apply and Verify may run more than once for the same value when a rotation is resumed.
+type Rotator interface {
+	// Generate returns the next value of the secret given its current value
+	Generate(ctx context.Context, current string) (string, error)
+	// Apply sets the pending value on the target, e.g. changes the database user's password
+	Apply(ctx context.Context, pending string) error
+	// Verify checks that the target accepts the pending value
+	Verify(ctx context.Context, pending string) error
+}
+
+// RotationStore is the part of the Secrets Manager API the rotation engine drives.
+// *secretsmanager.Client implements it; an in-memory store can stand in for it in tests.
+type RotationStore interface {
+	DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	PutSecretValue(ctx context.Context, params *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error)
+	UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error)
+}
+
+var (
+	rotatorsMu sync.RWMutex
+	rotators   = make(map[string]Rotator)
+)
+
+// RegisterRotator makes a rotator available to the local_rotate operation under the given name
+func RegisterRotator(name string, rotator Rotator) {
+	rotatorsMu.Lock()
+	defer rotatorsMu.Unlock()
+	rotators[name] = rotator
+}
+
+func getRotator(name string) (Rotator, bool) {
+	rotatorsMu.RLock()
+	defer rotatorsMu.RUnlock()
+	rotator, ok := rotators[name]
+	return rotator, ok
+}
+
+type RotationResult struct {
+	VersionId         string
+	PreviousVersionId string
+	// Resumed is set when an interrupted rotation was picked up from its AWSPENDING version
+	Resumed bool
+}
+
+// RotationEngine runs the createSecret, setSecret, testSecret and finishSecret steps of the
+// Secrets Manager rotation protocol from the runner instead of a Lambda. Progress is kept in
+// the AWSPENDING staging label, so a rotation interrupted at any step resumes where it stopped.
+type RotationEngine struct {
+	store   RotationStore
+	rotator Rotator
+}
+
+func NewRotationEngine(store RotationStore, rotator Rotator) *RotationEngine {
+	return &RotationEngine{store: store, rotator: rotator}
+}
+
+func (e *RotationEngine) Rotate(ctx context.Context, secretName string) (*RotationResult, error) {
+	description, err := e.store.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretName)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe secret: %w", err)
+	}
+	if description.DeletedDate != nil {
+		return nil, fmt.Errorf("secret %s is scheduled for deletion", secretName)
+	}
+
+	currentVersionId := getVersionIdForStage(description.VersionIdsToStages, StageCurrent)
+	pendingVersionId := getVersionIdForStage(description.VersionIdsToStages, StagePending)
+	// versions without the encoded marker, e.g. written by a rotation Lambda, follow the encoding tag
+	tagged := getTagMap(description.Tags)[EncodingTagKey] == EncodingBase64
+
+	// a previous run got through finishSecret but not the clean up of AWSPENDING
+	if pendingVersionId != "" && pendingVersionId == currentVersionId {
+		logrus.Infof("Rotation of secret %s already finished, removing stale %s label", secretName, StagePending)
+		if err := e.removePendingStage(ctx, secretName, pendingVersionId); err != nil {
+			return nil, err
+		}
+		return &RotationResult{VersionId: currentVersionId, Resumed: true}, nil
+	}
+
+	result := &RotationResult{PreviousVersionId: currentVersionId}
+	var pending string
+	if pendingVersionId != "" {
+		logrus.Infof("Resuming rotation of secret %s at version %s", secretName, pendingVersionId)
+		result.VersionId = pendingVersionId
+		result.Resumed = true
+		if pending, err = e.getValue(ctx, secretName, pendingVersionId, StagePending, tagged); err != nil {
+			return nil, err
+		}
+	} else {
+		// the new version keeps the encoding of the current one
+		result.VersionId = uuid.New().String()
+		if isEncodedVersion(currentVersionId) || tagged {
+			result.VersionId = newEncodedVersionId()
+		}
+		if pending, err = e.createSecret(ctx, secretName, result.VersionId, tagged); err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.Infof("Applying pending version %s of secret %s", result.VersionId, secretName)
+	if err := e.rotator.Apply(ctx, pending); err != nil {
+		return nil, fmt.Errorf("setSecret failed: %w", err)
+	}
+
+	logrus.Infof("Verifying pending version %s of secret %s", result.VersionId, secretName)
+	if err := e.rotator.Verify(ctx, pending); err != nil {
+		return nil, fmt.Errorf("testSecret failed: %w", err)
+	}
+
+	if err := e.finishSecret(ctx, secretName, result.VersionId, currentVersionId); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Successfully rotated secret %s to version %s", secretName, result.VersionId)
+	return result, nil
+}
+
+// createSecret generates the next value and stores it as the AWSPENDING version, base64 encoded
+// if the version ID marks it as encoded. The rotator only ever sees decoded values.
+func (e *RotationEngine) createSecret(ctx context.Context, secretName, versionId string, tagged bool) (string, error) {
+	current, err := e.getValue(ctx, secretName, "", StageCurrent, tagged)
+	if err != nil {
+		return "", err
+	}
+	pending, err := e.rotator.Generate(ctx, current)
+	if err != nil {
+		return "", fmt.Errorf("createSecret failed to generate value: %w", err)
+	}
+	stored := pending
+	if isEncodedVersion(versionId) {
+		stored = base64.StdEncoding.EncodeToString([]byte(pending))
+	}
+
+	logrus.Infof("Storing pending version %s of secret %s", versionId, secretName)
+	_, err = e.store.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:           aws.String(secretName),
+		ClientRequestToken: aws.String(versionId),
+		SecretString:       aws.String(stored),
+		VersionStages:      []string{StagePending},
+	})
+	if err != nil {
+		return "", fmt.Errorf("createSecret failed to store pending value: %w", err)
+	}
+	return pending, nil
+}
+
+// finishSecret makes the pending version current, which moves AWSPREVIOUS onto the old one
+func (e *RotationEngine) finishSecret(ctx context.Context, secretName, versionId, currentVersionId string) error {
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(secretName),
+		VersionStage:    aws.String(StageCurrent),
+		MoveToVersionId: aws.String(versionId),
+	}
+	if currentVersionId != "" {
+		input.RemoveFromVersionId = aws.String(currentVersionId)
+	}
+	if _, err := e.store.UpdateSecretVersionStage(ctx, input); err != nil {
+		return fmt.Errorf("finishSecret failed: %w", err)
+	}
+	return e.removePendingStage(ctx, secretName, versionId)
+}
+
+func (e *RotationEngine) removePendingStage(ctx context.Context, secretName, versionId string) error {
+	_, err := e.store.UpdateSecretVersionStage(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(secretName),
+		VersionStage:        aws.String(StagePending),
+		RemoveFromVersionId: aws.String(versionId),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s label: %w", StagePending, err)
+	}
+	return nil
+}
+
+// getValue reads the value of the version with the stage, decoding it if the version is marked encoded
+// or, without the marker, if the secret is tagged as encoded
+func (e *RotationEngine) getValue(ctx context.Context, secretName, versionId, stage string, tagged bool) (string, error) {
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretName),
+		VersionStage: aws.String(stage),
+	}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	output, err := e.store.GetSecretValue(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s value: %w", stage, err)
+	}
+	if output.SecretString == nil {
+		return "", fmt.Errorf("local rotation only supports string secrets")
+	}
+	if !isEncodedVersion(aws.ToString(output.VersionId)) && !tagged {
+		return *output.SecretString, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(*output.SecretString)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s value: %w", stage, err)
+	}
+	return string(decoded), nil
+}
+
+// PasswordRotatorName is the name the password rotator is registered under
+const PasswordRotatorName = "password"
+
+// PasswordRotator rotates secrets that have no external target to update, such as shared tokens,
+// to a new locally generated password. A JSON object secret has its "password" field replaced;
+// any other secret is replaced whole.
+type PasswordRotator struct {
+	Options common.PasswordOptions
+}
+
+func (r PasswordRotator) Generate(ctx context.Context, current string) (string, error) {
+	password, err := generateLocalPassword(r.Options)
+	if err != nil {
+		return "", err
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(current))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return password, nil
+	}
+	if _, ok := fields["password"]; !ok {
+		return password, nil
+	}
+	fields["password"] = password
+	value, err := json.Marshal(fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode secret: %w", err)
+	}
+	return string(value), nil
+}
+
+func (r PasswordRotator) Apply(ctx context.Context, pending string) error {
+	return nil
+}
+
+func (r PasswordRotator) Verify(ctx context.Context, pending string) error {
+	return nil
+}
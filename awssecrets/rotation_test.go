@@ -0,0 +1,275 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"strings"
+	"testing"
+)
+
+// memoryStore is an in-memory RotationStore for a single secret, following the staging label rules
+// of Secrets Manager: a label is on at most one version, and moving AWSCURRENT moves AWSPREVIOUS.
+type memoryStore struct {
+	values map[string]string
+	stages map[string]string
+	tags   map[string]string
+	puts   int
+}
+
+func newMemoryStore(currentVersionId, current string) *memoryStore {
+	return &memoryStore{
+		values: map[string]string{currentVersionId: current},
+		stages: map[string]string{StageCurrent: currentVersionId},
+	}
+}
+
+func (s *memoryStore) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	versionIdsToStages := make(map[string][]string)
+	for stage, versionId := range s.stages {
+		versionIdsToStages[versionId] = append(versionIdsToStages[versionId], stage)
+	}
+	return &secretsmanager.DescribeSecretOutput{Name: params.SecretId, VersionIdsToStages: versionIdsToStages, Tags: getTags(s.tags)}, nil
+}
+
+func (s *memoryStore) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	versionId := aws.ToString(params.VersionId)
+	if stage := aws.ToString(params.VersionStage); stage != "" {
+		if versionId != "" && s.stages[stage] != versionId {
+			return nil, &types.ResourceNotFoundException{Message: aws.String("version does not carry the stage")}
+		}
+		versionId = s.stages[stage]
+	}
+	value, ok := s.values[versionId]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("version not found")}
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: params.SecretId, VersionId: aws.String(versionId), SecretString: aws.String(value)}, nil
+}
+
+func (s *memoryStore) PutSecretValue(ctx context.Context, params *secretsmanager.PutSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.PutSecretValueOutput, error) {
+	versionId := aws.ToString(params.ClientRequestToken)
+	if existing, ok := s.values[versionId]; ok && existing != aws.ToString(params.SecretString) {
+		return nil, &types.ResourceExistsException{Message: aws.String("version exists with a different value")}
+	}
+	s.puts++
+	s.values[versionId] = aws.ToString(params.SecretString)
+	for _, stage := range params.VersionStages {
+		s.moveStage(stage, versionId)
+	}
+	return &secretsmanager.PutSecretValueOutput{Name: params.SecretId, VersionId: aws.String(versionId)}, nil
+}
+
+func (s *memoryStore) UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	stage := aws.ToString(params.VersionStage)
+	removeFrom, moveTo := aws.ToString(params.RemoveFromVersionId), aws.ToString(params.MoveToVersionId)
+	if removeFrom != "" && s.stages[stage] != removeFrom {
+		return nil, &types.InvalidParameterException{Message: aws.String("stage is not on the version to remove it from")}
+	}
+	if moveTo == "" {
+		delete(s.stages, stage)
+		return &secretsmanager.UpdateSecretVersionStageOutput{Name: params.SecretId}, nil
+	}
+	if _, ok := s.values[moveTo]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("version not found")}
+	}
+	s.moveStage(stage, moveTo)
+	return &secretsmanager.UpdateSecretVersionStageOutput{Name: params.SecretId}, nil
+}
+
+func (s *memoryStore) moveStage(stage, versionId string) {
+	if stage == StageCurrent {
+		if previous, ok := s.stages[StageCurrent]; ok && previous != versionId {
+			s.stages[StagePrevious] = previous
+		}
+	}
+	s.stages[stage] = versionId
+}
+
+// recordingRotator appends to the current value and records the values it applied and verified
+type recordingRotator struct {
+	applied    []string
+	verified   []string
+	failVerify error
+}
+
+func (r *recordingRotator) Generate(ctx context.Context, current string) (string, error) {
+	return current + "-next", nil
+}
+
+func (r *recordingRotator) Apply(ctx context.Context, pending string) error {
+	r.applied = append(r.applied, pending)
+	return nil
+}
+
+func (r *recordingRotator) Verify(ctx context.Context, pending string) error {
+	r.verified = append(r.verified, pending)
+	return r.failVerify
+}
+
+const (
+	currentVersionId = "11111111-1111-1111-1111-111111111111"
+	pendingVersionId = "22222222-2222-2222-2222-222222222222"
+)
+
+func TestRotateFresh(t *testing.T) {
+	store := newMemoryStore(currentVersionId, "secret")
+	rotator := &recordingRotator{}
+
+	result, err := NewRotationEngine(store, rotator).Rotate(context.Background(), "db-creds")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if result.Resumed || result.PreviousVersionId != currentVersionId || result.VersionId == currentVersionId {
+		t.Errorf("Rotate() = %+v, want a new version replacing %s", result, currentVersionId)
+	}
+	if store.stages[StageCurrent] != result.VersionId || store.stages[StagePrevious] != currentVersionId {
+		t.Errorf("stages = %v, want %s current and %s previous", store.stages, result.VersionId, currentVersionId)
+	}
+	if _, ok := store.stages[StagePending]; ok {
+		t.Errorf("stages = %v, want %s removed", store.stages, StagePending)
+	}
+	if got := store.values[result.VersionId]; got != "secret-next" {
+		t.Errorf("stored value = %q, want %q", got, "secret-next")
+	}
+	if strings.Join(rotator.applied, ",") != "secret-next" || strings.Join(rotator.verified, ",") != "secret-next" {
+		t.Errorf("applied %v and verified %v, want secret-next once each", rotator.applied, rotator.verified)
+	}
+}
+
+func TestRotateResumesFromPending(t *testing.T) {
+	store := newMemoryStore(currentVersionId, "secret")
+	rotator := &recordingRotator{failVerify: errors.New("target not ready")}
+	engine := NewRotationEngine(store, rotator)
+
+	if _, err := engine.Rotate(context.Background(), "db-creds"); err == nil || !strings.Contains(err.Error(), "testSecret failed") {
+		t.Fatalf("Rotate() error = %v, want testSecret failure", err)
+	}
+	interruptedVersionId := store.stages[StagePending]
+	if interruptedVersionId == "" || store.stages[StageCurrent] != currentVersionId {
+		t.Fatalf("stages = %v, want the pending version left for the next run", store.stages)
+	}
+
+	rotator.failVerify = nil
+	result, err := engine.Rotate(context.Background(), "db-creds")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if !result.Resumed || result.VersionId != interruptedVersionId || result.PreviousVersionId != currentVersionId {
+		t.Errorf("Rotate() = %+v, want version %s resumed", result, interruptedVersionId)
+	}
+	if store.puts != 1 {
+		t.Errorf("stored %d values, want the pending value stored once", store.puts)
+	}
+	if store.stages[StageCurrent] != interruptedVersionId {
+		t.Errorf("stages = %v, want %s current", store.stages, interruptedVersionId)
+	}
+	// the resumed run applies the stored pending value again rather than a new one
+	if strings.Join(rotator.applied, ",") != "secret-next,secret-next" {
+		t.Errorf("applied = %v, want the same pending value twice", rotator.applied)
+	}
+}
+
+func TestRotateCleansUpFinishedRotation(t *testing.T) {
+	store := newMemoryStore(currentVersionId, "secret")
+	store.values[pendingVersionId] = "secret-next"
+	store.stages[StagePrevious] = currentVersionId
+	store.stages[StageCurrent] = pendingVersionId
+	store.stages[StagePending] = pendingVersionId
+	rotator := &recordingRotator{}
+
+	result, err := NewRotationEngine(store, rotator).Rotate(context.Background(), "db-creds")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if !result.Resumed || result.VersionId != pendingVersionId {
+		t.Errorf("Rotate() = %+v, want version %s resumed", result, pendingVersionId)
+	}
+	if _, ok := store.stages[StagePending]; ok || store.stages[StageCurrent] != pendingVersionId {
+		t.Errorf("stages = %v, want only %s removed", store.stages, StagePending)
+	}
+	if len(rotator.applied) != 0 || store.puts != 0 {
+		t.Errorf("applied %v and stored %d values, want nothing redone", rotator.applied, store.puts)
+	}
+}
+
+func TestRotateKeepsEncoding(t *testing.T) {
+	encodedVersionId := encodedVersionIdPrefix + currentVersionId
+	store := newMemoryStore(encodedVersionId, base64.StdEncoding.EncodeToString([]byte("secret")))
+	rotator := &recordingRotator{}
+
+	result, err := NewRotationEngine(store, rotator).Rotate(context.Background(), "db-creds")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if !isEncodedVersion(result.VersionId) {
+		t.Errorf("new version %s is not marked encoded", result.VersionId)
+	}
+	if got, want := store.values[result.VersionId], base64.StdEncoding.EncodeToString([]byte("secret-next")); got != want {
+		t.Errorf("stored value = %q, want %q", got, want)
+	}
+	if strings.Join(rotator.applied, ",") != "secret-next" {
+		t.Errorf("applied = %v, want the decoded value", rotator.applied)
+	}
+}
+
+func TestRotateFollowsEncodingTag(t *testing.T) {
+	// a version written encoded by others carries no marker, only the secret's tag says it is encoded
+	store := newMemoryStore(currentVersionId, base64.StdEncoding.EncodeToString([]byte("secret")))
+	store.tags = map[string]string{EncodingTagKey: EncodingBase64}
+	rotator := &recordingRotator{}
+
+	result, err := NewRotationEngine(store, rotator).Rotate(context.Background(), "db-creds")
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if got, want := store.values[result.VersionId], base64.StdEncoding.EncodeToString([]byte("secret-next")); got != want {
+		t.Errorf("stored value = %q, want %q", got, want)
+	}
+	if strings.Join(rotator.applied, ",") != "secret-next" {
+		t.Errorf("applied = %v, want the decoded value", rotator.applied)
+	}
+}
+
+func TestPasswordRotator(t *testing.T) {
+	rotator := PasswordRotator{}
+
+	password, err := rotator.Generate(context.Background(), "old")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if len(password) != DefaultPasswordLength || password == "old" {
+		t.Errorf("Generate(plain) = %q, want a new %d character password", password, DefaultPasswordLength)
+	}
+
+	value, err := rotator.Generate(context.Background(), `{"username":"app","password":"old","port":5432}`)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		t.Fatalf("Generate(json) = %q, not JSON: %v", value, err)
+	}
+	if fields["username"] != "app" || fmt.Sprint(fields["port"]) != "5432" || fields["password"] == "old" {
+		t.Errorf("Generate(json) = %q, want only the password replaced", value)
+	}
+
+	if value, _ := rotator.Generate(context.Background(), `{"token":"old"}`); strings.Contains(value, "token") {
+		t.Errorf("Generate(json without password) = %q, want the whole value replaced", value)
+	}
+
+	rotator.Options = common.PasswordOptions{Length: 16, ExcludePunctuation: true, ExcludeCharacters: "0Oo1lI"}
+	password, err = rotator.Generate(context.Background(), "old")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if len(password) != 16 || strings.ContainsAny(password, punctuationCharacters+"0Oo1lI") {
+		t.Errorf("Generate(with options) = %q, want 16 characters following the options", password)
+	}
+}
@@ -0,0 +1,93 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"fmt"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// createHTTPClient builds the HTTP client shared by the Secrets Manager and STS clients.
+// It returns nil when no transport settings are configured, leaving the SDK default in place.
+func createHTTPClient(secretManagerConfig common.SecretManagerConfig) (*awshttp.BuildableClient, error) {
+	if secretManagerConfig.ProxyURL == "" && secretManagerConfig.ConnectTimeout <= 0 &&
+		secretManagerConfig.TLSHandshakeTimeout <= 0 && secretManagerConfig.ResponseHeaderTimeout <= 0 &&
+		secretManagerConfig.MaxIdleConns <= 0 {
+		return nil, nil
+	}
+
+	var proxy func(*http.Request) (*url.URL, error)
+	if secretManagerConfig.ProxyURL != "" {
+		proxyURL, err := url.Parse(secretManagerConfig.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		proxy = proxyFunc(proxyURL, secretManagerConfig.NoProxy)
+	}
+
+	client := awshttp.NewBuildableClient()
+	if secretManagerConfig.ConnectTimeout > 0 {
+		client = client.WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = time.Duration(secretManagerConfig.ConnectTimeout) * time.Second
+		})
+	}
+	return client.WithTransportOptions(func(tr *http.Transport) {
+		if proxy != nil {
+			tr.Proxy = proxy
+		}
+		if secretManagerConfig.TLSHandshakeTimeout > 0 {
+			tr.TLSHandshakeTimeout = time.Duration(secretManagerConfig.TLSHandshakeTimeout) * time.Second
+		}
+		if secretManagerConfig.ResponseHeaderTimeout > 0 {
+			tr.ResponseHeaderTimeout = time.Duration(secretManagerConfig.ResponseHeaderTimeout) * time.Second
+		}
+		if secretManagerConfig.MaxIdleConns > 0 {
+			tr.MaxIdleConns = secretManagerConfig.MaxIdleConns
+			tr.MaxIdleConnsPerHost = secretManagerConfig.MaxIdleConns
+		}
+	}), nil
+}
+
+// proxyFunc sends requests through proxyURL unless the host matches the no-proxy list
+func proxyFunc(proxyURL *url.URL, noProxy []string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// bypassProxy reports whether host matches a no-proxy entry. Entries are "*", a host name that
+// also matches its subdomains (optionally with a leading "." or "*."), an IP address or a CIDR range.
+func bypassProxy(host string, noProxy []string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if ip != nil {
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && cidr.Contains(ip) {
+				return true
+			}
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package awssecrets
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestBypassProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		noProxy []string
+		want    bool
+	}{
+		{name: "empty list", host: "secretsmanager.us-east-1.amazonaws.com", noProxy: nil, want: false},
+		{name: "wildcard", host: "secretsmanager.us-east-1.amazonaws.com", noProxy: []string{"*"}, want: true},
+		{name: "blank entries ignored", host: "example.com", noProxy: []string{"", "  "}, want: false},
+		{name: "exact host", host: "example.com", noProxy: []string{"example.com"}, want: true},
+		{name: "host is case insensitive", host: "Example.COM", noProxy: []string{"example.com"}, want: true},
+		{name: "entry is trimmed and case insensitive", host: "example.com", noProxy: []string{" EXAMPLE.com "}, want: true},
+		{name: "subdomain", host: "api.example.com", noProxy: []string{"example.com"}, want: true},
+		{name: "nested subdomain", host: "a.b.example.com", noProxy: []string{"example.com"}, want: true},
+		{name: "leading dot", host: "api.example.com", noProxy: []string{".example.com"}, want: true},
+		{name: "leading dot matches the domain itself", host: "example.com", noProxy: []string{".example.com"}, want: true},
+		{name: "leading wildcard", host: "api.example.com", noProxy: []string{"*.example.com"}, want: true},
+		{name: "suffix without label boundary", host: "badexample.com", noProxy: []string{"example.com"}, want: false},
+		{name: "parent domain not matched", host: "example.com", noProxy: []string{"api.example.com"}, want: false},
+		{name: "later entry matches", host: "vpce.internal", noProxy: []string{"example.com", "vpce.internal"}, want: true},
+		{name: "IPv4 address", host: "10.0.0.5", noProxy: []string{"10.0.0.5"}, want: true},
+		{name: "different IPv4 address", host: "10.0.0.6", noProxy: []string{"10.0.0.5"}, want: false},
+		{name: "IPv4 in CIDR", host: "10.1.2.3", noProxy: []string{"10.0.0.0/8"}, want: true},
+		{name: "IPv4 outside CIDR", host: "11.1.2.3", noProxy: []string{"10.0.0.0/8"}, want: false},
+		{name: "IPv6 address", host: "::1", noProxy: []string{"::1"}, want: true},
+		{name: "IPv6 in CIDR", host: "fd00::1", noProxy: []string{"fd00::/8"}, want: true},
+		{name: "IPv4 not in IPv6 CIDR", host: "10.0.0.1", noProxy: []string{"fd00::/8"}, want: false},
+		{name: "IP does not match by suffix", host: "192.168.0.1", noProxy: []string{"0.1"}, want: false},
+		{name: "CIDR does not match host names", host: "example.com", noProxy: []string{"10.0.0.0/8"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bypassProxy(tt.host, tt.noProxy); got != tt.want {
+				t.Errorf("bypassProxy(%q, %q) = %v, want %v", tt.host, tt.noProxy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	proxyURL, _ := url.Parse("http://proxy.internal:3128")
+	proxy := proxyFunc(proxyURL, []string{"169.254.169.254", ".internal"})
+
+	tests := []struct {
+		url  string
+		want *url.URL
+	}{
+		{url: "https://secretsmanager.us-east-1.amazonaws.com/", want: proxyURL},
+		{url: "http://169.254.169.254/latest/meta-data/", want: nil},
+		{url: "https://vault.internal:8443/", want: nil},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		got, err := proxy(req)
+		if err != nil {
+			t.Fatalf("proxy(%s) error = %v", tt.url, err)
+		}
+		if got != tt.want {
+			t.Errorf("proxy(%s) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
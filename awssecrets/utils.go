@@ -1,11 +1,21 @@
 package awssecrets
 
 import (
+	"aws-secret-manager-cgi/common"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
@@ -13,6 +23,45 @@ const (
 	PathSeparator   = "/"
 )
 
+// defaultSecretTags are applied to written secrets unless the store config sets default_tags
+var defaultSecretTags = map[string]string{"createdBy": "Harness"}
+
+// EncodingTagKey tags secrets whose current value was base64 encoded on write
+const (
+	EncodingTagKey = "encoding"
+	EncodingBase64 = "base64"
+)
+
+// encodedVersionIdPrefix starts the IDs of versions this handler writes base64 encoded, so fetch can
+// decode them without reading the encoding tag. Versions without it follow the tag.
+const encodedVersionIdPrefix = EncodingBase64 + "-"
+
+// ContentTypeBinary marks fetched values that are binary secrets returned base64 encoded
+const ContentTypeBinary = "application/octet-stream;base64"
+
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePrevious = "AWSPREVIOUS"
+	StagePending  = "AWSPENDING"
+)
+
+const (
+	MinRecoveryWindowDays = 7
+	MaxRecoveryWindowDays = 30
+)
+
+// policies for upserting a secret that is scheduled for deletion
+const (
+	PendingDeletionFail     = "fail"
+	PendingDeletionRestore  = "restore"
+	PendingDeletionRecreate = "recreate"
+)
+
+const (
+	deletionWaitAttempts = 10
+	deletionWaitInterval = time.Second
+)
+
 // isValidJSON checks if a string is valid JSON
 func isValidJSON(input string) bool {
 	var js json.RawMessage
@@ -52,8 +101,22 @@ func getValueFromJSON(input string, key string) string {
 	return string(valBytes)
 }
 
-// extractSecretInfo determines the secret name and key from the given record
-func extractSecretInfo(path string) (name string, key string) {
+// secretVersion pins a read to a version id or a staging label; the zero value reads AWSCURRENT
+type secretVersion struct {
+	versionId    string
+	versionStage string
+}
+
+// stagingLabelPattern matches the upper case staging labels accepted after "@" in a reference,
+// so names that merely contain "@", such as e-mail addresses, are left untouched
+var stagingLabelPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// versionIdPattern matches version IDs in the canonical hyphenated UUID form only,
+// optionally marked as base64 encoded
+var versionIdPattern = regexp.MustCompile(`^(` + encodedVersionIdPrefix + `)?[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// splitSecretKey splits the optional #key off a secret name, taking the rest of the name literally
+func splitSecretKey(path string) (name string, key string) {
 	if path != "" {
 		parts := strings.SplitN(path, "#", 2)
 		if len(parts) > 1 {
@@ -64,6 +127,86 @@ func extractSecretInfo(path string) (name string, key string) {
 	return "", ""
 }
 
+// extractSecretInfo determines the secret name, key and version from the given reference.
+// The reference has the form name[@version-id|@STAGING_LABEL][#key], e.g. db-creds@AWSPREVIOUS#password.
+// Only reads parse the version: AWS allows "@" in names, so writes use splitSecretKey.
+func extractSecretInfo(path string) (name string, key string, version secretVersion) {
+	name, key = splitSecretKey(path)
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		selector := name[i+1:]
+		if versionIdPattern.MatchString(selector) {
+			return name[:i], key, secretVersion{versionId: selector}
+		}
+		if stagingLabelPattern.MatchString(selector) {
+			return name[:i], key, secretVersion{versionStage: selector}
+		}
+	}
+	return name, key, secretVersion{}
+}
+
+// getSecretVersion lets the version fields on the secret take precedence over the reference
+func getSecretVersion(secret common.Secret, version secretVersion) secretVersion {
+	if secret.VersionId != "" || secret.VersionStage != "" {
+		return secretVersion{versionId: secret.VersionId, versionStage: secret.VersionStage}
+	}
+	return version
+}
+
+// getSecretValue returns the string value of the secret, or its binary value base64 encoded
+func getSecretValue(output *secretsmanager.GetSecretValueOutput) (value string, binary bool) {
+	if output.SecretString == nil && output.SecretBinary != nil {
+		return base64.StdEncoding.EncodeToString(output.SecretBinary), true
+	}
+	return aws.ToString(output.SecretString), false
+}
+
+// getSecretPayload returns the value to store: the plaintext as a string, base64 encoded first for
+// secrets flagged base64, or for binary secrets the base64 plaintext decoded into bytes
+func getSecretPayload(secret common.Secret) (*string, []byte, error) {
+	if secret.Binary {
+		if secret.Plaintext == nil {
+			return nil, nil, fmt.Errorf("binary secret %s requires base64 encoded plaintext", secret.Name)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(*secret.Plaintext)
+		if err != nil {
+			return nil, nil, fmt.Errorf("binary secret %s is not valid base64: %v", secret.Name, err.Error())
+		}
+		return nil, decoded, nil
+	}
+	if !secret.Base64 || secret.Plaintext == nil {
+		return secret.Plaintext, nil, nil
+	}
+	if secret.Base64Encoded {
+		if _, err := base64.StdEncoding.DecodeString(*secret.Plaintext); err != nil {
+			return nil, nil, fmt.Errorf("secret %s is not valid base64: %v", secret.Name, err.Error())
+		}
+		return secret.Plaintext, nil, nil
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(*secret.Plaintext))
+	return &encoded, nil, nil
+}
+
+// isBase64Tagged reports whether the secret value is stored base64 encoded
+func isBase64Tagged(secret common.Secret) bool {
+	return secret.Base64 && !secret.Binary
+}
+
+// isBase64 reports whether s is valid standard base64, i.e. could be a value stored encoded
+func isBase64(s string) bool {
+	_, err := base64.StdEncoding.DecodeString(s)
+	return s != "" && err == nil
+}
+
+// newEncodedVersionId returns a version ID that marks the version as stored base64 encoded
+func newEncodedVersionId() string {
+	return encodedVersionIdPrefix + uuid.New().String()
+}
+
+// isEncodedVersion reports whether the version was written base64 encoded
+func isEncodedVersion(versionId string) bool {
+	return strings.HasPrefix(versionId, encodedVersionIdPrefix)
+}
+
 // decode does a base64 decode of the given string
 func decode(s string, decode bool, name string) (string, error) {
 	if decode {
@@ -90,3 +233,225 @@ func getFullPathWithoutStrippingPrefixSlash(basePath, secretPath string) string
 	basePath = strings.TrimRight(basePath, PathSeparator)
 	return basePath + PathSeparator + secretPath
 }
+
+// getRetryCount returns how many times the SDK retried the call that produced the given result metadata
+func getRetryCount(metadata middleware.Metadata) int {
+	attempts, ok := retry.GetAttemptResults(metadata)
+	if !ok || len(attempts.Results) == 0 {
+		return 0
+	}
+	return len(attempts.Results) - 1
+}
+
+// getListFilters converts list options into ListSecrets filters
+func getListFilters(options common.ListOptions) []types.Filter {
+	var filters []types.Filter
+	addFilter := func(key types.FilterNameStringType, value string) {
+		if value != "" {
+			filters = append(filters, types.Filter{Key: key, Values: []string{value}})
+		}
+	}
+	addFilter(types.FilterNameStringTypeName, options.NamePrefix)
+	addFilter(types.FilterNameStringTypeTagKey, options.TagKey)
+	addFilter(types.FilterNameStringTypeTagValue, options.TagValue)
+	addFilter(types.FilterNameStringTypeDescription, options.Description)
+	return filters
+}
+
+// getTagMap converts AWS tags into a map keyed by tag key
+func getTagMap(tags []types.Tag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	tagMap := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		tagMap[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tagMap
+}
+
+// getSecretMetadata converts a ListSecrets entry, which never carries the secret value
+func getSecretMetadata(entry types.SecretListEntry) common.SecretMetadata {
+	return common.SecretMetadata{
+		Name:             aws.ToString(entry.Name),
+		Arn:              aws.ToString(entry.ARN),
+		Description:      aws.ToString(entry.Description),
+		CreatedDate:      entry.CreatedDate,
+		LastChangedDate:  entry.LastChangedDate,
+		LastAccessedDate: entry.LastAccessedDate,
+		LastRotatedDate:  entry.LastRotatedDate,
+		DeletedDate:      entry.DeletedDate,
+		Tags:             getTagMap(entry.Tags),
+		RotationEnabled:  aws.ToBool(entry.RotationEnabled),
+		NextRotationDate: entry.NextRotationDate,
+	}
+}
+
+// getSecretDescription converts DescribeSecret output into the describe response
+func getSecretDescription(output *secretsmanager.DescribeSecretOutput) *common.DescribeSecretResponse {
+	description := &common.DescribeSecretResponse{
+		SecretMetadata: common.SecretMetadata{
+			Name:             aws.ToString(output.Name),
+			Arn:              aws.ToString(output.ARN),
+			Description:      aws.ToString(output.Description),
+			CreatedDate:      output.CreatedDate,
+			LastChangedDate:  output.LastChangedDate,
+			LastAccessedDate: output.LastAccessedDate,
+			LastRotatedDate:  output.LastRotatedDate,
+			DeletedDate:      output.DeletedDate,
+			Tags:             getTagMap(output.Tags),
+			RotationEnabled:  aws.ToBool(output.RotationEnabled),
+			NextRotationDate: output.NextRotationDate,
+		},
+		KmsKeyId:          aws.ToString(output.KmsKeyId),
+		Versions:          output.VersionIdsToStages,
+		RotationLambdaArn: aws.ToString(output.RotationLambdaARN),
+		PrimaryRegion:     aws.ToString(output.PrimaryRegion),
+	}
+	if output.RotationRules != nil {
+		description.RotationRules = &common.RotationRules{
+			AutomaticallyAfterDays: int(aws.ToInt64(output.RotationRules.AutomaticallyAfterDays)),
+			Duration:               aws.ToString(output.RotationRules.Duration),
+			ScheduleExpression:     aws.ToString(output.RotationRules.ScheduleExpression),
+		}
+	}
+	description.Replication = getReplicationStatus(output.ReplicationStatus)
+	return description
+}
+
+// getVersionIdForStage returns the version carrying the given staging label, or "" if none does
+func getVersionIdForStage(versionIdsToStages map[string][]string, stage string) string {
+	for versionId, stages := range versionIdsToStages {
+		for _, s := range stages {
+			if s == stage {
+				return versionId
+			}
+		}
+	}
+	return ""
+}
+
+// getRotationRules converts the rotation schedule, or returns nil if none is set
+func getRotationRules(rules common.RotationRules) *types.RotationRulesType {
+	if rules.AutomaticallyAfterDays <= 0 && rules.Duration == "" && rules.ScheduleExpression == "" {
+		return nil
+	}
+	rotationRules := &types.RotationRulesType{}
+	if rules.AutomaticallyAfterDays > 0 {
+		rotationRules.AutomaticallyAfterDays = aws.Int64(int64(rules.AutomaticallyAfterDays))
+	}
+	if rules.Duration != "" {
+		rotationRules.Duration = aws.String(rules.Duration)
+	}
+	if rules.ScheduleExpression != "" {
+		rotationRules.ScheduleExpression = aws.String(rules.ScheduleExpression)
+	}
+	return rotationRules
+}
+
+// getSecretTags merges the default tags, the secret's own tags and the encoding tag
+func getSecretTags(secretManagerConfig common.SecretManagerConfig, secret common.Secret) map[string]string {
+	defaultTags := secretManagerConfig.DefaultTags
+	if defaultTags == nil {
+		defaultTags = defaultSecretTags
+	}
+	tags := make(map[string]string, len(defaultTags)+len(secret.Tags)+1)
+	for k, v := range defaultTags {
+		tags[k] = v
+	}
+	for k, v := range secret.Tags {
+		tags[k] = v
+	}
+	if isBase64Tagged(secret) {
+		tags[EncodingTagKey] = EncodingBase64
+	} else {
+		delete(tags, EncodingTagKey)
+	}
+	return tags
+}
+
+// getUpdatedTags returns the tags an update of the secret leaves it with. Without a new value the
+// current version stays, and so does the encoding tag describing it.
+func getUpdatedTags(secretManagerConfig common.SecretManagerConfig, secret common.Secret, current map[string]string) map[string]string {
+	tags := getSecretTags(secretManagerConfig, secret)
+	if secret.Plaintext == nil {
+		if encoding, ok := current[EncodingTagKey]; ok {
+			tags[EncodingTagKey] = encoding
+		} else {
+			delete(tags, EncodingTagKey)
+		}
+	}
+	return tags
+}
+
+// getTags converts a tag map into AWS tags sorted by key, so requests are deterministic
+func getTags(tagMap map[string]string) []types.Tag {
+	keys := make([]string, 0, len(tagMap))
+	for k := range tagMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make([]types.Tag, 0, len(keys))
+	for _, k := range keys {
+		tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(tagMap[k])})
+	}
+	return tags
+}
+
+// getTagChanges returns the tags to set and the tag keys to remove to go from current to desired.
+// Tags in the reserved aws: namespace are never removed.
+func getTagChanges(current, desired map[string]string) (map[string]string, []string) {
+	toSet := make(map[string]string)
+	for k, v := range desired {
+		if currentValue, ok := current[k]; !ok || currentValue != v {
+			toSet[k] = v
+		}
+	}
+	var toRemove []string
+	for k := range current {
+		if _, ok := desired[k]; !ok && !strings.HasPrefix(k, "aws:") {
+			toRemove = append(toRemove, k)
+		}
+	}
+	sort.Strings(toRemove)
+	return toSet, toRemove
+}
+
+// getReplicationStatus converts the per-region replication status
+func getReplicationStatus(replicationStatus []types.ReplicationStatusType) []common.ReplicationStatus {
+	var statuses []common.ReplicationStatus
+	for _, replica := range replicationStatus {
+		statuses = append(statuses, common.ReplicationStatus{
+			Region:           aws.ToString(replica.Region),
+			KmsKeyId:         aws.ToString(replica.KmsKeyId),
+			Status:           string(replica.Status),
+			StatusMessage:    aws.ToString(replica.StatusMessage),
+			LastAccessedDate: replica.LastAccessedDate,
+		})
+	}
+	return statuses
+}
+
+// getReplicaRegions converts replica regions, leaving the KMS key unset to use the region's default key
+func getReplicaRegions(regions []common.ReplicaRegion) []types.ReplicaRegionType {
+	var replicaRegions []types.ReplicaRegionType
+	for _, region := range regions {
+		replicaRegion := types.ReplicaRegionType{Region: aws.String(region.Region)}
+		if region.KmsKeyId != "" {
+			replicaRegion.KmsKeyId = aws.String(region.KmsKeyId)
+		}
+		replicaRegions = append(replicaRegions, replicaRegion)
+	}
+	return replicaRegions
+}
+
+// getReplicaConfig returns the store config for a client in the replica region. Custom endpoints
+// are dropped, as they point at the primary region, e.g. its VPC interface endpoints.
+func getReplicaConfig(secretManagerConfig common.SecretManagerConfig, region string) common.SecretManagerConfig {
+	replicaConfig := secretManagerConfig
+	replicaConfig.Region = region
+	replicaConfig.EndpointURL = ""
+	replicaConfig.STSEndpointURL = ""
+	return replicaConfig
+}
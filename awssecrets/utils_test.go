@@ -0,0 +1,191 @@
+package awssecrets
+
+import (
+	"aws-secret-manager-cgi/common"
+	"reflect"
+	"testing"
+)
+
+func TestExtractSecretInfo(t *testing.T) {
+	const versionId = "01234567-89ab-cdef-0123-456789abcdef"
+	tests := []struct {
+		path        string
+		wantName    string
+		wantKey     string
+		wantVersion secretVersion
+	}{
+		{path: "", wantName: ""},
+		{path: "db-creds", wantName: "db-creds"},
+		{path: "db-creds#password", wantName: "db-creds", wantKey: "password"},
+		{path: "db-creds#nested.key#with#hashes", wantName: "db-creds", wantKey: "nested.key#with#hashes"},
+		{path: "db-creds@AWSPREVIOUS", wantName: "db-creds", wantVersion: secretVersion{versionStage: StagePrevious}},
+		{path: "db-creds@AWSPREVIOUS#password", wantName: "db-creds", wantKey: "password", wantVersion: secretVersion{versionStage: StagePrevious}},
+		{path: "db-creds@MY_LABEL2", wantName: "db-creds", wantVersion: secretVersion{versionStage: "MY_LABEL2"}},
+		{path: "db-creds@" + versionId, wantName: "db-creds", wantVersion: secretVersion{versionId: versionId}},
+		{path: "db-creds@" + versionId + "#password", wantName: "db-creds", wantKey: "password", wantVersion: secretVersion{versionId: versionId}},
+		{path: "team/a@b@AWSCURRENT", wantName: "team/a@b", wantVersion: secretVersion{versionStage: StageCurrent}},
+		// names that merely contain "@" are left untouched
+		{path: "user@example.com", wantName: "user@example.com"},
+		{path: "user@example.com#password", wantName: "user@example.com", wantKey: "password"},
+		{path: "db-creds@", wantName: "db-creds@"},
+		{path: "db-creds@current", wantName: "db-creds@current"},
+		{path: "db-creds@1LABEL", wantName: "db-creds@1LABEL"},
+		// only the canonical hyphenated UUID form is a version ID
+		{path: "a@0123456789abcdef0123456789abcdef", wantName: "a@0123456789abcdef0123456789abcdef"},
+		{path: "a@urn:uuid:" + versionId, wantName: "a@urn:uuid:" + versionId},
+		{path: "a@{" + versionId + "}", wantName: "a@{" + versionId + "}"},
+		{path: "a@" + versionId + "0", wantName: "a@" + versionId + "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			name, key, version := extractSecretInfo(tt.path)
+			if name != tt.wantName || key != tt.wantKey || version != tt.wantVersion {
+				t.Errorf("extractSecretInfo(%q) = (%q, %q, %+v), want (%q, %q, %+v)",
+					tt.path, name, key, version, tt.wantName, tt.wantKey, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestSplitSecretKey(t *testing.T) {
+	tests := []struct {
+		path     string
+		wantName string
+		wantKey  string
+	}{
+		{path: "", wantName: ""},
+		{path: "team/svc@PROD", wantName: "team/svc@PROD"},
+		{path: "team/svc@PROD#password", wantName: "team/svc@PROD", wantKey: "password"},
+		{path: "db-creds@01234567-89ab-cdef-0123-456789abcdef", wantName: "db-creds@01234567-89ab-cdef-0123-456789abcdef"},
+	}
+	for _, tt := range tests {
+		if name, key := splitSecretKey(tt.path); name != tt.wantName || key != tt.wantKey {
+			t.Errorf("splitSecretKey(%q) = (%q, %q), want (%q, %q)", tt.path, name, key, tt.wantName, tt.wantKey)
+		}
+	}
+}
+
+func TestIsEncodedVersion(t *testing.T) {
+	encoded := newEncodedVersionId()
+	if !isEncodedVersion(encoded) {
+		t.Errorf("isEncodedVersion(%q) = false, want true", encoded)
+	}
+	if len(encoded) < 32 || len(encoded) > 64 {
+		t.Errorf("len(%q) = %d, want a valid client request token length of 32 to 64", encoded, len(encoded))
+	}
+	if _, _, version := extractSecretInfo("db-creds@" + encoded); version.versionId != encoded {
+		t.Errorf("extractSecretInfo did not accept encoded version ID %q", encoded)
+	}
+	for _, versionId := range []string{"", "01234567-89ab-cdef-0123-456789abcdef", "BASE64-01234567-89ab-cdef-0123-456789abcdef"} {
+		if isEncodedVersion(versionId) {
+			t.Errorf("isEncodedVersion(%q) = true, want false", versionId)
+		}
+	}
+}
+
+func TestGetTagChanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    map[string]string
+		desired    map[string]string
+		wantSet    map[string]string
+		wantRemove []string
+	}{
+		{name: "nothing", current: nil, desired: nil, wantSet: map[string]string{}},
+		{name: "unchanged", current: map[string]string{"env": "prod"}, desired: map[string]string{"env": "prod"}, wantSet: map[string]string{}},
+		{name: "added", current: nil, desired: map[string]string{"env": "prod"}, wantSet: map[string]string{"env": "prod"}},
+		{name: "changed", current: map[string]string{"env": "dev"}, desired: map[string]string{"env": "prod"}, wantSet: map[string]string{"env": "prod"}},
+		{name: "empty value is a value", current: map[string]string{"env": "prod"}, desired: map[string]string{"env": ""}, wantSet: map[string]string{"env": ""}},
+		{
+			name:       "removed in key order",
+			current:    map[string]string{"team": "a", "env": "prod", "owner": "b"},
+			desired:    map[string]string{"env": "prod"},
+			wantSet:    map[string]string{},
+			wantRemove: []string{"owner", "team"},
+		},
+		{
+			name:       "aws tags are kept",
+			current:    map[string]string{"aws:cloudformation:stack-name": "stack", "old": "x"},
+			desired:    map[string]string{"new": "y"},
+			wantSet:    map[string]string{"new": "y"},
+			wantRemove: []string{"old"},
+		},
+		{
+			name:       "keys are case sensitive",
+			current:    map[string]string{"Env": "prod"},
+			desired:    map[string]string{"env": "prod"},
+			wantSet:    map[string]string{"env": "prod"},
+			wantRemove: []string{"Env"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toSet, toRemove := getTagChanges(tt.current, tt.desired)
+			if !reflect.DeepEqual(toSet, tt.wantSet) || !reflect.DeepEqual(toRemove, tt.wantRemove) {
+				t.Errorf("getTagChanges(%v, %v) = (%v, %v), want (%v, %v)",
+					tt.current, tt.desired, toSet, toRemove, tt.wantSet, tt.wantRemove)
+			}
+		})
+	}
+}
+
+func TestGetUpdatedTags(t *testing.T) {
+	value := "value"
+	config := common.SecretManagerConfig{DefaultTags: map[string]string{}}
+	encoded := map[string]string{EncodingTagKey: EncodingBase64}
+	tests := []struct {
+		name    string
+		secret  common.Secret
+		current map[string]string
+		want    map[string]string
+	}{
+		{
+			name:    "tags only keep the encoding tag",
+			secret:  common.Secret{Tags: map[string]string{"env": "prod"}},
+			current: encoded,
+			want:    map[string]string{"env": "prod", EncodingTagKey: EncodingBase64},
+		},
+		{
+			name:    "tags only do not add the encoding tag",
+			secret:  common.Secret{Tags: map[string]string{"env": "prod"}, Base64: true},
+			current: nil,
+			want:    map[string]string{"env": "prod"},
+		},
+		{
+			name:    "plain value removes the encoding tag",
+			secret:  common.Secret{Tags: map[string]string{"env": "prod"}, Plaintext: &value},
+			current: encoded,
+			want:    map[string]string{"env": "prod"},
+		},
+		{
+			name:    "encoded value sets the encoding tag",
+			secret:  common.Secret{Tags: map[string]string{"env": "prod"}, Plaintext: &value, Base64: true},
+			current: nil,
+			want:    map[string]string{"env": "prod", EncodingTagKey: EncodingBase64},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getUpdatedTags(config, tt.secret, tt.current); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getUpdatedTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetReplicaConfig(t *testing.T) {
+	config := common.SecretManagerConfig{
+		Region:         "us-east-1",
+		Prefix:         "team-a",
+		EndpointURL:    "https://vpce-1.secretsmanager.us-east-1.vpce.amazonaws.com",
+		STSEndpointURL: "https://vpce-2.sts.us-east-1.vpce.amazonaws.com",
+	}
+	got := getReplicaConfig(config, "eu-west-1")
+	want := common.SecretManagerConfig{Region: "eu-west-1", Prefix: "team-a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getReplicaConfig() = %+v, want %+v", got, want)
+	}
+	if config.Region != "us-east-1" || config.EndpointURL == "" {
+		t.Errorf("getReplicaConfig() changed the store config to %+v", config)
+	}
+}
@@ -1,6 +1,9 @@
 package common
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Input struct {
 	SecretParams *SecretParams `json:"secret_params"`
@@ -11,6 +14,10 @@ type SecretParams struct {
 	Config         *SecretManagerConfig `json:"store_config"`
 	Secret         *Secret              `json:"secret"`
 	ExistingSecret *Secret              `json:"existing_secret"`
+	// used only in list flow
+	ListOptions *ListOptions `json:"list_options"`
+	// used only in rotation flows
+	Rotation *RotationOptions `json:"rotation"`
 }
 
 type SecretManagerConfig struct {
@@ -20,9 +27,63 @@ type SecretManagerConfig struct {
 	AssumeIamRoleOnRunner bool   `json:"assume_iam_role"`
 	AssumeStsRoleOnRunner bool   `json:"assume_sts_role"`
 	AssumeStsRoleDuration int    `json:"assume_sts_role_duration"`
+	AssumeWebIdentity     bool   `json:"assume_web_identity"`
+	WebIdentityToken      string `json:"web_identity_token,omitempty"`
+	WebIdentityTokenFile  string `json:"web_identity_token_file,omitempty"`
 	RoleArn               string `json:"role_arn"`
 	ExternalName          string `json:"external_name"`
 	Prefix                string `json:"prefix,omitempty"`
+	// RoleChain lists roles assumed in sequence for assume_sts_role, each with the credentials of the one before
+	RoleChain []RoleHop `json:"role_chain,omitempty"`
+	// RoleSessionName is used for STS sessions instead of a random name, so CloudTrail shows the caller
+	RoleSessionName   string            `json:"role_session_name,omitempty"`
+	SourceIdentity    string            `json:"source_identity,omitempty"`
+	SessionTags       map[string]string `json:"session_tags,omitempty"`
+	TransitiveTagKeys []string          `json:"transitive_tag_keys,omitempty"`
+	// SessionPolicy is an inline IAM policy that scopes down the assumed role session
+	SessionPolicy string `json:"session_policy,omitempty"`
+	// EndpointURL and STSEndpointURL override the default endpoints, e.g. for VPC interface endpoints or emulators
+	EndpointURL          string `json:"endpoint_url,omitempty"`
+	STSEndpointURL       string `json:"sts_endpoint_url,omitempty"`
+	UseFIPSEndpoint      bool   `json:"use_fips_endpoint,omitempty"`
+	UseDualStackEndpoint bool   `json:"use_dual_stack_endpoint,omitempty"`
+	// CABundle is a PEM encoded CA bundle, inline or as a file path, trusted instead of the system roots
+	CABundle string `json:"ca_bundle,omitempty"`
+	// ProxyURL routes AWS calls through an HTTP proxy, except for hosts matching NoProxy
+	ProxyURL string   `json:"proxy_url,omitempty"`
+	NoProxy  []string `json:"no_proxy,omitempty"`
+	// timeouts are in seconds
+	ConnectTimeout        int `json:"connect_timeout,omitempty"`
+	TLSHandshakeTimeout   int `json:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout int `json:"response_header_timeout,omitempty"`
+	MaxIdleConns          int `json:"max_idle_conns,omitempty"`
+	// RetryMode is "standard" (default) or "adaptive"; MaxBackoff is in seconds
+	RetryMode               string `json:"retry_mode,omitempty"`
+	MaxAttempts             int    `json:"max_attempts,omitempty"`
+	MaxBackoff              int    `json:"max_backoff,omitempty"`
+	RetryTokenBucketSize    int    `json:"retry_token_bucket_size,omitempty"`
+	DisableRetryTokenBucket bool   `json:"disable_retry_token_bucket,omitempty"`
+	// Profile selects a named profile from the shared config files for the runner's default credential chain
+	Profile                string   `json:"profile,omitempty"`
+	SharedConfigFiles      []string `json:"shared_config_files,omitempty"`
+	SharedCredentialsFiles []string `json:"shared_credentials_files,omitempty"`
+	// CredentialProcessTimeout bounds a profile's credential_process, in seconds
+	CredentialProcessTimeout int `json:"credential_process_timeout,omitempty"`
+	// CheckPermissions makes connect probe every action the handler uses under Prefix
+	CheckPermissions bool `json:"check_permissions,omitempty"`
+	// RecoveryWindowDays is how long deleted secrets can be restored, 7 to 30 days; AWS defaults to 30
+	RecoveryWindowDays int `json:"recovery_window_days,omitempty"`
+	// DefaultTags are set on every secret written; they default to createdBy: Harness
+	DefaultTags map[string]string `json:"default_tags,omitempty"`
+	// ReplicaRegions are the default replica regions of created secrets
+	ReplicaRegions []ReplicaRegion `json:"replica_regions,omitempty"`
+}
+
+type RoleHop struct {
+	RoleArn     string `json:"role_arn"`
+	ExternalId  string `json:"external_id,omitempty"`
+	Duration    int    `json:"duration,omitempty"`
+	SessionName string `json:"session_name,omitempty"`
 }
 
 type Secret struct {
@@ -30,11 +91,86 @@ type Secret struct {
 	Name      string  `json:"name"`
 	Plaintext *string `json:"plaintext"`
 	Base64    bool    `json:"base64"`
+	// Base64Encoded says the plaintext is already base64; otherwise base64 secrets are encoded on write
+	Base64Encoded bool `json:"base64_encoded,omitempty"`
+	// Binary marks a base64 plaintext that is stored as SecretBinary
+	Binary bool `json:"binary,omitempty"`
+	// VersionId or VersionStage pin fetches to a version; they take precedence over name@version references
+	VersionId    string `json:"version_id,omitempty"`
+	VersionStage string `json:"version_stage,omitempty"`
+	// ForceDelete deletes without a recovery window; by default deletes can be restored
+	ForceDelete bool `json:"force_delete,omitempty"`
+	// OnPendingDeletion is the create/update policy for a secret scheduled for deletion: fail (default), restore or recreate
+	OnPendingDeletion string `json:"on_pending_deletion,omitempty"`
+	// Generate replaces the plaintext with a generated password on create and update
+	Generate *PasswordOptions `json:"generate,omitempty"`
+	// Tags are added to the default tags on create; on update they replace the secret's tags
+	Tags        map[string]string `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+	KmsKeyId    string            `json:"kms_key_id,omitempty"`
+	// ReplicaRegions are replicated to on create and by replicate and remove_replica;
+	// they default to the store config replica regions
+	ReplicaRegions []ReplicaRegion `json:"replica_regions,omitempty"`
+}
+
+type ReplicaRegion struct {
+	Region   string `json:"region"`
+	KmsKeyId string `json:"kms_key_id,omitempty"`
+}
+
+type PasswordOptions struct {
+	// Length defaults to 32
+	Length             int    `json:"length,omitempty"`
+	ExcludeCharacters  string `json:"exclude_characters,omitempty"`
+	ExcludeLowercase   bool   `json:"exclude_lowercase,omitempty"`
+	ExcludeUppercase   bool   `json:"exclude_uppercase,omitempty"`
+	ExcludeNumbers     bool   `json:"exclude_numbers,omitempty"`
+	ExcludePunctuation bool   `json:"exclude_punctuation,omitempty"`
+	IncludeSpace       bool   `json:"include_space,omitempty"`
+	// RequireEachIncludedType defaults to true
+	RequireEachIncludedType *bool `json:"require_each_included_type,omitempty"`
+	// Local generates with crypto/rand instead of calling GetRandomPassword
+	Local bool `json:"local,omitempty"`
+	// ReturnValue includes the generated password in the response; by default it is never returned
+	ReturnValue bool `json:"return_value,omitempty"`
+}
+
+type ListOptions struct {
+	// NamePrefix defaults to the store config prefix
+	NamePrefix  string `json:"name_prefix,omitempty"`
+	TagKey      string `json:"tag_key,omitempty"`
+	TagValue    string `json:"tag_value,omitempty"`
+	Description string `json:"description,omitempty"`
+	MaxResults  int    `json:"max_results,omitempty"`
+	NextToken   string `json:"next_token,omitempty"`
+}
+
+type RotationOptions struct {
+	LambdaArn string `json:"lambda_arn,omitempty"`
+	// Rotator names the rotator registered for local_rotate
+	Rotator string `json:"rotator,omitempty"`
+	// Generate sets the password rules for the password rotator
+	Generate *PasswordOptions `json:"generate,omitempty"`
+	RotationRules
+	// RotateImmediately also rotates when configuring rotation; rotate always rotates immediately
+	RotateImmediately bool `json:"rotate_immediately,omitempty"`
 }
 
 type ValidationResponse struct {
 	IsValid bool   `json:"valid"`
 	Error   *Error `json:"error"`
+	// CredentialExpiry is when the credentials in use expire; omitted for credentials that never expire
+	CredentialExpiry *time.Time `json:"credential_expiry,omitempty"`
+	// Permissions is only reported when check_permissions is set in the store config
+	Permissions []PermissionCheck `json:"permissions,omitempty"`
+}
+
+type PermissionCheck struct {
+	Action  string `json:"action"`
+	Allowed bool   `json:"allowed"`
+	// Inconclusive is set when the probe could not show whether the action is allowed
+	Inconclusive bool   `json:"inconclusive,omitempty"`
+	Reason       string `json:"reason,omitempty"`
 }
 
 type OperationStatus string
@@ -49,6 +185,19 @@ type OperationResponse struct {
 	Message         string          `json:"message"`
 	Error           *Error          `json:"error"`
 	OperationStatus OperationStatus `json:"status"`
+	// Retries is the number of retries the SDK made before the operation succeeded
+	Retries int `json:"retries,omitempty"`
+	// VersionId is the version the operation made current, PreviousVersionId the one it replaced
+	VersionId         string `json:"version_id,omitempty"`
+	PreviousVersionId string `json:"previous_version_id,omitempty"`
+	// DeletionDate is when a secret scheduled for deletion will be deleted
+	DeletionDate *time.Time `json:"deletion_date,omitempty"`
+	// NextRotationDate is reported by rotation operations
+	NextRotationDate *time.Time `json:"next_rotation_date,omitempty"`
+	// Value is the generated password, only when the generate options ask for it
+	Value string `json:"value,omitempty"`
+	// Replication is the per-region status reported by replication operations
+	Replication []ReplicationStatus `json:"replication,omitempty"`
 }
 
 type Error struct {
@@ -63,9 +212,68 @@ type ErrorResponse struct {
 	Status  int    `json:"status"`
 }
 
+// IdentityResponse for whoami tasks
+type IdentityResponse struct {
+	Account          string     `json:"account"`
+	Arn              string     `json:"arn"`
+	UserId           string     `json:"user_id"`
+	CredentialSource string     `json:"credential_source"`
+	CredentialExpiry *time.Time `json:"credential_expiry,omitempty"`
+	Error            *Error     `json:"error"`
+}
+
+// ListSecretsResponse for list tasks; NextToken is set when more secrets are available
+type ListSecretsResponse struct {
+	Secrets   []SecretMetadata `json:"secrets"`
+	NextToken string           `json:"next_token,omitempty"`
+	Error     *Error           `json:"error"`
+}
+
+type SecretMetadata struct {
+	Name             string            `json:"name"`
+	Arn              string            `json:"arn"`
+	Description      string            `json:"description,omitempty"`
+	CreatedDate      *time.Time        `json:"created_date,omitempty"`
+	LastChangedDate  *time.Time        `json:"last_changed_date,omitempty"`
+	LastAccessedDate *time.Time        `json:"last_accessed_date,omitempty"`
+	LastRotatedDate  *time.Time        `json:"last_rotated_date,omitempty"`
+	DeletedDate      *time.Time        `json:"deleted_date,omitempty"`
+	Tags             map[string]string `json:"tags,omitempty"`
+	RotationEnabled  bool              `json:"rotation_enabled"`
+	NextRotationDate *time.Time        `json:"next_rotation_date,omitempty"`
+}
+
+// DescribeSecretResponse for describe tasks; it never includes the secret value
+type DescribeSecretResponse struct {
+	SecretMetadata
+	KmsKeyId          string              `json:"kms_key_id,omitempty"`
+	Versions          map[string][]string `json:"versions,omitempty"`
+	RotationLambdaArn string              `json:"rotation_lambda_arn,omitempty"`
+	RotationRules     *RotationRules      `json:"rotation_rules,omitempty"`
+	PrimaryRegion     string              `json:"primary_region,omitempty"`
+	Replication       []ReplicationStatus `json:"replication,omitempty"`
+	Error             *Error              `json:"error"`
+}
+
+type RotationRules struct {
+	AutomaticallyAfterDays int    `json:"automatically_after_days,omitempty"`
+	Duration               string `json:"duration,omitempty"`
+	ScheduleExpression     string `json:"schedule_expression,omitempty"`
+}
+
+type ReplicationStatus struct {
+	Region           string     `json:"region"`
+	KmsKeyId         string     `json:"kms_key_id,omitempty"`
+	Status           string     `json:"status"`
+	StatusMessage    string     `json:"status_message,omitempty"`
+	LastAccessedDate *time.Time `json:"last_accessed_date,omitempty"`
+}
+
 // SecretResponse for fetch secret tasks
 type SecretResponse struct {
 	Value string `json:"value"`
+	// ContentType is set for binary secrets, whose value is returned base64 encoded
+	ContentType string `json:"content_type,omitempty"`
 }
 
 type SecretManager interface {
@@ -76,4 +284,17 @@ type SecretManager interface {
 	UpsertSecret(ctx context.Context, secret Secret, existingSecret *Secret) (*OperationResponse, error)
 	RenameSecret(ctx context.Context, secret Secret, existingSecret *Secret) (*OperationResponse, error)
 	DeleteSecret(ctx context.Context, secret Secret) (*OperationResponse, error)
+	WhoAmI(ctx context.Context) (*IdentityResponse, error)
+	ListSecrets(ctx context.Context, options ListOptions) (*ListSecretsResponse, error)
+	DescribeSecret(ctx context.Context, secret Secret) (*DescribeSecretResponse, error)
+	RollbackSecret(ctx context.Context, secret Secret) (*OperationResponse, error)
+	RestoreSecret(ctx context.Context, secret Secret) (*OperationResponse, error)
+	RotateSecret(ctx context.Context, secret Secret) (*OperationResponse, error)
+	ConfigureRotation(ctx context.Context, secret Secret, options RotationOptions) (*OperationResponse, error)
+	CancelRotation(ctx context.Context, secret Secret) (*OperationResponse, error)
+	RotateSecretLocally(ctx context.Context, secret Secret, options RotationOptions) (*OperationResponse, error)
+	GenerateSecret(ctx context.Context, secret Secret, existingSecret *Secret) (*OperationResponse, error)
+	ReplicateSecret(ctx context.Context, secret Secret) (*OperationResponse, error)
+	RemoveReplica(ctx context.Context, secret Secret) (*OperationResponse, error)
+	PromoteReplica(ctx context.Context, secret Secret) (*OperationResponse, error)
 }
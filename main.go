@@ -1,10 +1,12 @@
 package main
 
 import (
+	"aws-secret-manager-cgi/awssecrets"
 	"aws-secret-manager-cgi/secrets"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"net/http/cgi"
+	"os"
 )
 
 func main() {
@@ -12,7 +14,23 @@ func main() {
 		FullTimestamp: true,
 	})
 
+	// rotators available to local_rotate; teams add their own here
+	awssecrets.RegisterRotator(awssecrets.PasswordRotatorName, awssecrets.PasswordRotator{})
+
 	http.HandleFunc("/", secrets.HandleRequest)
+
+	// CGI stays the default so existing runners keep working; `serve` runs a long-lived HTTP server instead
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		opts, err := parseServerOptions(os.Args[2:])
+		if err != nil {
+			log.WithError(err).Fatal("Invalid server options")
+		}
+		if err := serve(opts, http.DefaultServeMux); err != nil {
+			log.WithError(err).Fatal("Failed to serve HTTP")
+		}
+		return
+	}
+
 	err := cgi.Serve(http.DefaultServeMux)
 
 	if err != nil {
@@ -3,7 +3,6 @@ package secrets
 import (
 	"aws-secret-manager-cgi/awssecrets"
 	"aws-secret-manager-cgi/common"
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,7 +29,7 @@ func HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	operation := strings.ToLower(in.SecretParams.Action)
 
 	var result interface{}
@@ -46,10 +45,48 @@ func HandleRequest(w http.ResponseWriter, r *http.Request) {
 		result, _ = secretManager.UpsertSecret(ctx, *in.SecretParams.Secret, nil)
 	case "update":
 		result, _ = secretManager.UpsertSecret(ctx, *in.SecretParams.Secret, in.SecretParams.ExistingSecret)
+	case "generate":
+		result, _ = secretManager.GenerateSecret(ctx, *in.SecretParams.Secret, in.SecretParams.ExistingSecret)
 	case "rename":
 		result, _ = secretManager.RenameSecret(ctx, *in.SecretParams.Secret, in.SecretParams.ExistingSecret)
 	case "delete":
 		result, _ = secretManager.DeleteSecret(ctx, *in.SecretParams.Secret)
+	case "whoami":
+		result, _ = secretManager.WhoAmI(ctx)
+	case "list":
+		options := common.ListOptions{}
+		if in.SecretParams.ListOptions != nil {
+			options = *in.SecretParams.ListOptions
+		}
+		result, _ = secretManager.ListSecrets(ctx, options)
+	case "describe":
+		result, _ = secretManager.DescribeSecret(ctx, *in.SecretParams.Secret)
+	case "rollback":
+		result, _ = secretManager.RollbackSecret(ctx, *in.SecretParams.Secret)
+	case "restore":
+		result, _ = secretManager.RestoreSecret(ctx, *in.SecretParams.Secret)
+	case "rotate":
+		result, _ = secretManager.RotateSecret(ctx, *in.SecretParams.Secret)
+	case "configure_rotation":
+		if in.SecretParams.Rotation == nil {
+			SendErrorResponse(w, errors.New("empty rotation"), "Rotation options are missing", http.StatusBadRequest)
+			return
+		}
+		result, _ = secretManager.ConfigureRotation(ctx, *in.SecretParams.Secret, *in.SecretParams.Rotation)
+	case "cancel_rotation":
+		result, _ = secretManager.CancelRotation(ctx, *in.SecretParams.Secret)
+	case "local_rotate":
+		if in.SecretParams.Rotation == nil {
+			SendErrorResponse(w, errors.New("empty rotation"), "Rotation options are missing", http.StatusBadRequest)
+			return
+		}
+		result, _ = secretManager.RotateSecretLocally(ctx, *in.SecretParams.Secret, *in.SecretParams.Rotation)
+	case "replicate":
+		result, _ = secretManager.ReplicateSecret(ctx, *in.SecretParams.Secret)
+	case "remove_replica":
+		result, _ = secretManager.RemoveReplica(ctx, *in.SecretParams.Secret)
+	case "promote_replica":
+		result, _ = secretManager.PromoteReplica(ctx, *in.SecretParams.Secret)
 	default:
 		SendErrorResponse(w, errors.New("invalid action"), fmt.Sprintf("The specified action %s is not supported", operation), http.StatusBadRequest)
 		return
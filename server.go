@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type serverOptions struct {
+	addr            string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	shutdownTimeout time.Duration
+}
+
+func parseServerOptions(args []string) (serverOptions, error) {
+	var opts serverOptions
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.StringVar(&opts.addr, "addr", ":8080", "address to listen on")
+	fs.DurationVar(&opts.readTimeout, "read-timeout", 30*time.Second, "maximum duration for reading the entire request")
+	fs.DurationVar(&opts.writeTimeout, "write-timeout", 60*time.Second, "maximum duration before timing out writes of the response")
+	fs.DurationVar(&opts.shutdownTimeout, "shutdown-timeout", 30*time.Second, "maximum duration to wait for in-flight requests on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return serverOptions{}, err
+	}
+	return opts, nil
+}
+
+// serve runs handler behind a net/http server until SIGTERM or SIGINT is received,
+// then drains in-flight requests before returning
+func serve(opts serverOptions, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:         opts.addr,
+		Handler:      handler,
+		ReadTimeout:  opts.readTimeout,
+		WriteTimeout: opts.writeTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Infof("Listening on %s", opts.addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+	}
+
+	log.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	log.Info("Server stopped")
+	return nil
+}